@@ -0,0 +1,288 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// --- CHECK ---
+
+// Slurm walltimes: M, M:S, H:M:S, D-H, D-H:M, D-H:M:S or unlimited
+var timePattern = regexp.MustCompile(`^(\d+(:\d+){0,2}|\d+-\d+(:\d+){0,2}|INFINITE|UNLIMITED)$`)
+
+// Slurm memory sizes: a number with an optional K, M, G or T suffix
+var memPattern = regexp.MustCompile(`^\d+[KMGTkmgt]?$`)
+
+// Two-part walltimes, which sbatch reads as minutes:seconds
+var minSecPattern = regexp.MustCompile(`^\d+:\d+$`)
+
+// validateTime reports walltimes sbatch would reject, and M:S walltimes that
+// are almost always meant as hours:minutes
+func validateTime(s string) error {
+	if minSecPattern.MatchString(s) {
+		h, m, _ := strings.Cut(s, ":")
+		hours, _ := strconv.Atoi(h)
+		minutes, _ := strconv.Atoi(m)
+		return fmt.Errorf("ambiguous time %q: Slurm reads it as minutes:seconds (use HH:MM:SS, e.g. %02d:%02d:00)", s, hours, minutes)
+	}
+	if !timePattern.MatchString(s) {
+		return fmt.Errorf("invalid time %q (use HH:MM:SS, D-HH:MM:SS or minutes)", s)
+	}
+	return nil
+}
+
+// validateMem reports memory sizes sbatch would reject
+func validateMem(s string) error {
+	if trimmed, ok := strings.CutSuffix(strings.ToUpper(s), "B"); ok && memPattern.MatchString(trimmed) {
+		return fmt.Errorf("invalid memory %q (Slurm suffixes have no B, use %s)", s, trimmed)
+	}
+	if !memPattern.MatchString(s) {
+		return fmt.Errorf("invalid memory %q (use a number with K, M, G or T, e.g. 4G)", s)
+	}
+	return nil
+}
+
+// validateOpenMode accepts the log modes of sbatch --open-mode
+func validateOpenMode(s string) error {
+	if s != "append" && s != "truncate" {
+		return fmt.Errorf("invalid open mode %q (use append or truncate)", s)
+	}
+	return nil
+}
+
+// Signal specs of sbatch --signal: [{R|B}:]<sig_num|sig_name>[@sig_time]
+var signalPattern = regexp.MustCompile(`^([RB]:)?(SIG)?[A-Z0-9]+(@\d+)?$`)
+
+// validateSignal reports --signal values sbatch would reject
+func validateSignal(s string) error {
+	if !signalPattern.MatchString(s) {
+		return fmt.Errorf("invalid signal %q (use e.g. B:USR1@300)", s)
+	}
+	return nil
+}
+
+// gpusPattern matches --gpus values: a count with an optional GPU type, e.g. a100:2
+var gpusPattern = regexp.MustCompile(`^([A-Za-z0-9_.\-]+:)?\d+$`)
+
+// validateGpus reports --gpus values that are not [type:]count
+func validateGpus(s string) error {
+	if !gpusPattern.MatchString(s) {
+		return fmt.Errorf("invalid GPU request %q (use a count or type:count, e.g. a100:2)", s)
+	}
+	if gpuType, _, ok := strings.Cut(s, ":"); ok {
+		return validateMIG(gpuType)
+	}
+	return nil
+}
+
+// gresPattern matches one GRES entry: name[:type][:count], e.g. gpu:a100:2
+var gresPattern = regexp.MustCompile(`^[A-Za-z0-9_]+(:[A-Za-z0-9_.\-]+)?(:\d+)?$`)
+
+// validateGres reports GRES strings sbatch would reject, checking MIG profiles of GPUs
+func validateGres(s string) error {
+	for _, entry := range strings.Split(s, ",") {
+		if !gresPattern.MatchString(entry) {
+			return fmt.Errorf("invalid GRES %q (use name[:type][:count], e.g. gpu:a100:2)", entry)
+		}
+		parts := strings.Split(entry, ":")
+		if parts[0] == "gpu" && len(parts) > 1 && !isInteger(parts[1]) {
+			if err := validateMIG(parts[1]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// migPattern matches MIG profiles as GPU types, e.g. 3g.20gb or a100_3g.20gb
+var migPattern = regexp.MustCompile(`^([A-Za-z0-9]+_)*\d+g\.\d+gb$`)
+
+// validateMIG checks GPU types that look like a MIG slice (a "g." in the name)
+// against the <compute>g.<memory>gb profile format
+func validateMIG(gpuType string) error {
+	if strings.Contains(gpuType, "g.") && !migPattern.MatchString(gpuType) {
+		return fmt.Errorf("invalid MIG profile %q (use <slices>g.<memory>gb, e.g. a100_3g.20gb)", gpuType)
+	}
+	return nil
+}
+
+// validateContainerGPU accepts the apptainer GPU options
+func validateContainerGPU(s string) error {
+	if s != "nv" && s != "rocm" {
+		return fmt.Errorf("invalid container GPU support %q (use nv or rocm)", s)
+	}
+	return nil
+}
+
+// extPattern matches a single file extension such as .sh
+var extPattern = regexp.MustCompile(`^\.[A-Za-z0-9_-]+$`)
+
+// validateExt accepts a script extension with one leading dot, e.g. .slurm
+func validateExt(s string) error {
+	if !extPattern.MatchString(s) {
+		return fmt.Errorf("invalid extension %q (use one extension with a leading dot, e.g. .sh)", s)
+	}
+	return nil
+}
+
+// validateShell accepts a known shell name or an absolute interpreter path,
+// possibly with arguments
+func validateShell(s string) error {
+	if _, ok := shells[s]; ok {
+		return nil
+	}
+	if fields := strings.Fields(s); len(fields) > 0 && filepath.IsAbs(fields[0]) {
+		return nil
+	}
+	return fmt.Errorf("invalid shell %q (use bash, bash-login, env, zsh or an absolute path)", s)
+}
+
+// validateGPUDirective accepts the ways a cluster takes GPU requests
+func validateGPUDirective(s string) error {
+	if s != "gres" && s != "gpus-per-node" {
+		return fmt.Errorf("invalid GPU directive %q (use gres or gpus-per-node)", s)
+	}
+	return nil
+}
+
+// Events of sbatch --mail-type
+var mailTypes = []string{"NONE", "BEGIN", "END", "FAIL", "REQUEUE", "ALL", "INVALID_DEPEND", "STAGE_OUT",
+	"TIME_LIMIT", "TIME_LIMIT_90", "TIME_LIMIT_80", "TIME_LIMIT_50", "ARRAY_TASKS"}
+
+// validateMailType reports --mail-type lists with events sbatch does not know
+func validateMailType(s string) error {
+	for _, event := range strings.Split(s, ",") {
+		if !slices.Contains(mailTypes, strings.ToUpper(event)) {
+			return fmt.Errorf("invalid mail type %q (use a list of %s)", event, strings.Join(mailTypes, ", "))
+		}
+	}
+	return nil
+}
+
+// checkedString is a string flag whose values must pass check when set
+type checkedString struct {
+	value *string
+	check func(string) error
+}
+
+func (f checkedString) String() string {
+	if f.value == nil {
+		return ""
+	}
+	return *f.value
+}
+
+func (f checkedString) Set(v string) error {
+	if err := f.check(v); err != nil {
+		return err
+	}
+	*f.value = v
+	return nil
+}
+
+// countFlag is an int flag for a resource count, which must be at least 1
+type countFlag struct{ value *int }
+
+func (f countFlag) String() string {
+	if f.value == nil {
+		return ""
+	}
+	return strconv.Itoa(*f.value)
+}
+
+func (f countFlag) Set(v string) error {
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return err
+	}
+	if n < 1 {
+		return fmt.Errorf("%d is not a count (must be at least 1)", n)
+	}
+	*f.value = n
+	return nil
+}
+
+// checkShell rejects settings whose generated code only runs under bash
+func checkShell(c Config) error {
+	if isZsh(c) && (c.Retries > 0 || c.ProfileCmd) {
+		return errors.New("--retries and --profile-cmd export bash functions and need a bash --shell")
+	}
+	return nil
+}
+
+// jobProblems lists everything about a job that sbatch or the shell would reject
+func jobProblems(job Job) []string {
+	var problems []string
+	if err := validateTime(job.Conf.Time); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if err := validateMem(job.Conf.Mem); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if job.Conf.CPUs < 1 {
+		problems = append(problems, fmt.Sprintf("invalid cpus %d (must be at least 1)", job.Conf.CPUs))
+	}
+	if job.Conf.Nodes < 1 || job.Conf.NTasks < 1 {
+		problems = append(problems, fmt.Sprintf("invalid nodes %d or ntasks %d (must be at least 1)", job.Conf.Nodes, job.Conf.NTasks))
+	}
+	if job.Conf.Retries < 0 || job.Conf.RetryDelay < 0 {
+		problems = append(problems, fmt.Sprintf("invalid retries %d or retry-delay %d (must not be negative)", job.Conf.Retries, job.Conf.RetryDelay))
+	}
+	// The same tokenizer as the script writer, which falls back to the raw line
+	if _, err := splitWords(job.Command); err != nil {
+		problems = append(problems, "unbalanced quotes, expansion or trailing backslash in command")
+	}
+	return problems
+}
+
+// checkJobs reports every problem in the batch by input line without writing
+// anything, starting with inputErr, the lines the input readers rejected
+func checkJobs(w io.Writer, jobs []Job, conf Config, inputErr error) error {
+	count := 0
+	report := func(job Job, msg string) {
+		fmt.Fprintf(w, "%s:%d: %s\n", sourceName(job.Source), job.Line, msg)
+		count++
+	}
+
+	if inputErr != nil {
+		for _, err := range splitErrors(inputErr) {
+			if le, ok := err.(*lineError); ok {
+				report(Job{Source: le.Source, Line: le.Line}, le.Err.Error())
+				continue
+			}
+			fmt.Fprintf(w, "%v\n", err)
+			count++
+		}
+	}
+
+	for _, job := range jobs {
+		for _, msg := range jobProblems(job) {
+			report(job, msg)
+		}
+	}
+
+	// Names, dependencies and templates are checked by rendering in memory
+	scripts, err := renderScripts(jobs, conf)
+	if err != nil {
+		fmt.Fprintf(w, "%v\n", err)
+		count++
+	}
+	for _, s := range scripts {
+		if s.Shared != "" && len(s.Jobs) == 1 {
+			// Legal, the job gets a hashed suffix, but usually a mistake
+			fmt.Fprintf(w, "%s:%d: warning: job name %s is used by other jobs too, renamed to %s\n", sourceName(s.Job.Source), s.Job.Line, s.Shared, s.Name)
+		}
+	}
+
+	if count > 0 {
+		return fmt.Errorf("check found %d problem(s) in %d job(s)", count, len(jobs))
+	}
+	fmt.Fprintf(w, "[slurmify] Check passed: %d job(s)\n", len(jobs))
+	return nil
+}
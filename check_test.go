@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// --check reports every bad line of the input, not just the first
+func TestCheckReportsEveryBadLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cmds.txt")
+	input := "#slurmify mem=4Q\necho a\n#slurmify bogus=1\necho b\necho ok\n#slurmify time=soon\necho c\n"
+	if err := os.WriteFile(path, []byte(input), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	conf := defaultConfig()
+	conf.Account = "acct"
+	conf.Inputs = inputList{path}
+	jobs, _, inputErr := loadJobs(conf, nil)
+	if inputErr == nil {
+		t.Fatal("bad directives were accepted")
+	}
+
+	var out strings.Builder
+	if err := checkJobs(&out, jobs, conf, inputErr); err == nil {
+		t.Error("check passed")
+	}
+	for _, line := range []string{"1", "3", "6"} {
+		if !strings.Contains(out.String(), path+":"+line+": ") {
+			t.Errorf("no problem reported for line %s:\n%s", line, out.String())
+		}
+	}
+}
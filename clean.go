@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// --- CLEAN ---
+
+// runClean removes generated scripts and job records, and logs when asked
+func runClean(args []string) error {
+	conf := defaultConfig()
+	fset := newCommandFlags("clean", &conf)
+
+	var withLogs bool
+	fset.BoolVar(&withLogs, "logs", false, "Also remove .out/.err files and GPU traces from the logs directory")
+
+	if err := parseArgs(fset, args, "slurmify clean [-O <dir>] [-L <dir>] [--logs]"); err != nil {
+		return err
+	}
+
+	scripts, err := removeMatching(conf.OutputDir, "*"+conf.Ext, "*"+metaExt, "*"+stampExt, jobsFileName, submitWrapperName, sentinelName, makefileName, manifestFileName)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("[slurmify] Removed %d file(s) from %s/\n", scripts, conf.OutputDir)
+
+	if withLogs {
+		logs, err := removeMatching(conf.LogsDir, "*.out", "*.err", "*_gpu.csv")
+		if err != nil {
+			return err
+		}
+		fmt.Printf("[slurmify] Removed %d file(s) from %s/\n", logs, conf.LogsDir)
+	}
+	return nil
+}
+
+// removeMatching deletes files matching any pattern, then the directory if it is empty
+func removeMatching(dir string, patterns ...string) (int, error) {
+	removed := 0
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return removed, err
+		}
+		for _, path := range matches {
+			if err := os.Remove(path); err != nil {
+				return removed, fmt.Errorf("could not remove %s: %w", path, err)
+			}
+			removed++
+		}
+	}
+	// Leaves the directory in place if anything else lives there
+	_ = os.Remove(dir)
+	return removed, nil
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- CLUSTER ---
+
+// partitionInfo queries scontrol for the settings of a partition, e.g. MaxTime
+func partitionInfo(name string) (map[string]string, error) {
+	var stderr strings.Builder
+	cmd := exec.Command("scontrol", "show", "partition", "--oneliner", name)
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("scontrol failed: %w: %s", err, msg)
+		}
+		return nil, fmt.Errorf("scontrol failed: %w", err)
+	}
+
+	info := map[string]string{}
+	for _, field := range strings.Fields(string(out)) {
+		if key, value, ok := strings.Cut(field, "="); ok {
+			info[key] = value
+		}
+	}
+	if info["PartitionName"] == "" {
+		return nil, fmt.Errorf("scontrol did not describe partition %s", name)
+	}
+	return info, nil
+}
+
+// partitionProblems lists the requests of a script that exceed the limits of its partition
+func partitionProblems(c Config, info map[string]string) []string {
+	var problems []string
+	if limit, ok, err := parseTime(info["MaxTime"]); err == nil && ok {
+		if d, ok, err := parseTime(c.Time); err == nil && (!ok || d > limit) {
+			problems = append(problems, fmt.Sprintf("time %s exceeds MaxTime %s", c.Time, info["MaxTime"]))
+		}
+	}
+	// MaxMemPerNode is in megabytes
+	if limit, err := strconv.ParseInt(info["MaxMemPerNode"], 10, 64); err == nil {
+		if kib, err := nodeMem(c); err == nil && kib > limit<<10 {
+			problems = append(problems, fmt.Sprintf("mem %s per node exceeds MaxMemPerNode %s", formatMem(kib), formatMem(limit<<10)))
+		}
+	}
+	if limit, err := strconv.Atoi(info["MaxCPUsPerNode"]); err == nil {
+		if cpus := c.CPUs * tasksPerNode(c); cpus > limit {
+			problems = append(problems, fmt.Sprintf("%d CPUs per node exceed MaxCPUsPerNode %d", cpus, limit))
+		}
+	}
+	return problems
+}
+
+// partitionFeatures queries sinfo for the node features offered in each partition
+func partitionFeatures() (map[string]map[string]bool, error) {
+	var stderr strings.Builder
+	cmd := exec.Command("sinfo", "--noheader", "--format=%R|%f")
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("sinfo failed: %w: %s", err, msg)
+		}
+		return nil, fmt.Errorf("sinfo failed: %w", err)
+	}
+
+	features := map[string]map[string]bool{}
+	for _, line := range strings.Split(string(out), "\n") {
+		partition, list, ok := strings.Cut(strings.TrimSpace(line), "|")
+		if !ok {
+			continue
+		}
+		if features[partition] == nil {
+			features[partition] = map[string]bool{}
+		}
+		for _, f := range strings.Split(list, ",") {
+			features[partition][f] = true
+		}
+	}
+	return features, nil
+}
+
+// constraintFeatures lists the feature names in a constraint expression like
+// "[skylake|cascadelake]&ib" or "gpu*2"
+func constraintFeatures(expr string) []string {
+	var names []string
+	for _, name := range strings.FieldsFunc(expr, func(r rune) bool { return strings.ContainsRune("&|,()[]", r) }) {
+		name, _, _ = strings.Cut(name, "*")
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// validateCluster warns about scripts that request more than their partition
+// allows or features it does not offer, once per problem with the first script
+// that has it
+func validateCluster(scripts []Script, rep *report) {
+	partitions := map[string]map[string]string{}
+	var features map[string]map[string]bool
+	var problems []string
+	first := map[string]string{}
+	count := map[string]int{}
+	for _, s := range scripts {
+		if s.Conf.Constraint != "" && features == nil {
+			var err error
+			if features, err = partitionFeatures(); err != nil {
+				rep.warnf("Could not check node features: %v", err)
+				features = map[string]map[string]bool{}
+			}
+		}
+
+		name := s.Conf.Partition
+		info, queried := partitions[name]
+		if !queried {
+			var err error
+			if info, err = partitionInfo(name); err != nil {
+				rep.warnf("Could not check partition %s: %v", name, err)
+			}
+			partitions[name] = info
+		}
+		if info == nil {
+			continue
+		}
+		found := partitionProblems(s.Conf, info)
+		if offered, ok := features[name]; ok {
+			for _, f := range constraintFeatures(s.Conf.Constraint) {
+				if !offered[f] {
+					found = append(found, fmt.Sprintf("feature %s is not offered by any node", f))
+				}
+			}
+		}
+		for _, problem := range found {
+			problem += " of partition " + name
+			if count[problem] == 0 {
+				problems = append(problems, problem)
+				first[problem] = s.Path
+			}
+			count[problem]++
+		}
+	}
+	for _, problem := range problems {
+		if n := count[problem]; n > 1 {
+			rep.warnf("%s: %s (and %d more script(s))", first[problem], problem, n-1)
+		} else {
+			rep.warnf("%s: %s", first[problem], problem)
+		}
+	}
+}
+
+// partitionAuto is the --partition value that picks a partition from sinfo
+const partitionAuto = "auto"
+
+// nodeGroup is one line of sinfo, the nodes of a partition with the same resources
+type nodeGroup struct {
+	Partition string
+	Time      time.Duration
+	Unlimited bool
+	MemKiB    int64
+	CPUs      int
+	GPUs      int
+	Idle      int
+}
+
+// sinfoGroups queries sinfo for the node groups of every partition that is up
+func sinfoGroups() ([]nodeGroup, error) {
+	var stderr strings.Builder
+	cmd := exec.Command("sinfo", "--noheader", "--format=%R|%a|%l|%m|%c|%G|%F")
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("sinfo failed: %w: %s", err, msg)
+		}
+		return nil, fmt.Errorf("sinfo failed: %w", err)
+	}
+
+	var groups []nodeGroup
+	for _, line := range strings.Split(string(out), "\n") {
+		f := strings.Split(strings.TrimSpace(line), "|")
+		if len(f) < 7 || f[1] != "up" {
+			continue
+		}
+		g := nodeGroup{Partition: f[0]}
+		// Sizes that vary between nodes are printed as the minimum with a "+"
+		g.MemKiB, _ = strconv.ParseInt(strings.TrimSuffix(f[3], "+"), 10, 64)
+		g.MemKiB <<= 10
+		g.CPUs, _ = strconv.Atoi(strings.TrimSuffix(f[4], "+"))
+		for _, res := range strings.Split(f[5], ",") {
+			res, _, _ = strings.Cut(res, "(")
+			g.GPUs += gpuCount(res)
+		}
+		// Node counts are allocated/idle/other/total
+		if counts := strings.Split(f[6], "/"); len(counts) == 4 {
+			g.Idle, _ = strconv.Atoi(counts[1])
+		}
+		d, ok, err := parseTime(strings.ToUpper(f[2]))
+		if err != nil {
+			continue
+		}
+		g.Time, g.Unlimited = d, !ok
+		groups = append(groups, g)
+	}
+	return groups, nil
+}
+
+// fits reports whether one node of a group can hold a job's share of its request
+func fits(g nodeGroup, c Config) bool {
+	if c.CPUs*tasksPerNode(c) > g.CPUs || gpuCount(c.Gres) > g.GPUs {
+		return false
+	}
+	if kib, err := nodeMem(c); err == nil && kib > g.MemKiB {
+		return false
+	}
+	d, ok, err := parseTime(c.Time)
+	return err == nil && (g.Unlimited || ok && d <= g.Time)
+}
+
+// candidate is a partition with nodes that fit a job
+type candidate struct {
+	nodeGroup
+	HasGPUs bool
+}
+
+// choosePartition picks the partition for a job among those with fitting nodes,
+// ranked to keep the queue short and the charge low: CPU-only partitions first
+// for jobs without GPUs, then partitions with idle nodes, then the smallest
+// nodes, the most idle nodes and the shortest time limit
+func choosePartition(c Config, groups []nodeGroup) (candidate, bool) {
+	var order []string
+	byName := map[string]*candidate{}
+	for _, g := range groups {
+		if !fits(g, c) {
+			continue
+		}
+		cand, ok := byName[g.Partition]
+		if !ok {
+			cand = &candidate{nodeGroup: g}
+			cand.Idle = 0
+			byName[g.Partition] = cand
+			order = append(order, g.Partition)
+		}
+		cand.Idle += g.Idle
+		cand.MemKiB = min(cand.MemKiB, g.MemKiB)
+		cand.HasGPUs = cand.HasGPUs || g.GPUs > 0
+	}
+	if len(order) == 0 {
+		return candidate{}, false
+	}
+
+	wantGPUs := gpuCount(c.Gres) > 0
+	better := func(a, b *candidate) bool {
+		switch {
+		case !wantGPUs && a.HasGPUs != b.HasGPUs:
+			return !a.HasGPUs
+		case (a.Idle > 0) != (b.Idle > 0):
+			return a.Idle > 0
+		case a.MemKiB != b.MemKiB:
+			return a.MemKiB < b.MemKiB
+		case a.Idle != b.Idle:
+			return a.Idle > b.Idle
+		case a.Unlimited != b.Unlimited:
+			return !a.Unlimited
+		}
+		return a.Time < b.Time
+	}
+	best := byName[order[0]]
+	for _, name := range order[1:] {
+		if cand := byName[name]; better(cand, best) {
+			best = cand
+		}
+	}
+	return *best, true
+}
+
+// selectPartitions resolves --partition auto for every script of the batch
+// that uses it, sized like the script: a packed chunk needs the CPUs of all of
+// its concurrent commands. sinfo is queried once for the whole batch.
+func selectPartitions(jobs []Job, groups [][]int, pack packing) error {
+	var nodes []nodeGroup
+	queried := false
+	for _, members := range groups {
+		first := jobs[members[0]]
+		if first.Conf.Partition != partitionAuto {
+			continue
+		}
+		if !queried {
+			var err error
+			if nodes, err = sinfoGroups(); err != nil {
+				return fmt.Errorf("--partition auto: %w", err)
+			}
+			queried = true
+		}
+		c := first.Conf
+		if pack.Mode != "" && len(members) > 1 {
+			c.CPUs *= pack.Width
+		}
+		cand, ok := choosePartition(c, nodes)
+		if !ok {
+			request := fmt.Sprintf("%d CPU(s), %s, %s", c.CPUs, memRequest(c), c.Time)
+			if c.Gres != "" {
+				request += ", " + c.Gres
+			}
+			return fmt.Errorf("%s: line %d: --partition auto: no partition that is up fits %s",
+				sourceName(first.Source), first.Line, request)
+		}
+		limit := "unlimited"
+		if !cand.Unlimited {
+			limit = formatTime(cand.Time)
+		}
+		// The script takes the settings of its first job; its other jobs are
+		// updated too so that they are reported with the same partition
+		for _, i := range members {
+			jobs[i].Conf.Partition = cand.Partition
+			jobs[i].Conf.partitionNote = fmt.Sprintf("%d idle node(s), time limit %s", cand.Idle, limit)
+		}
+	}
+	return nil
+}
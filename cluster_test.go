@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// A packed script needs nodes for the CPUs of all its concurrent commands
+func TestAutoPartitionFitsPackedScript(t *testing.T) {
+	bin := t.TempDir()
+	sinfo := "#!/bin/sh\ncat <<'X'\nstandard|up|2-00:00:00|180000|36|(null)|10/3/0/13\nlargemem|up|7-00:00:00|1500000|72|(null)|2/5/0/7\nX\n"
+	if err := os.WriteFile(filepath.Join(bin, "sinfo"), []byte(sinfo), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	conf := defaultConfig()
+	conf.Account = "acct"
+	conf.Partition = partitionAuto
+	conf.CPUs = 24
+	conf.Pack = "parallel:2"
+	jobs, _, err := readCommands(strings.NewReader("echo a > a1\necho b > b2\necho c > c3\n"), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scripts, err := renderScripts(jobs, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 48 CPUs for the pair, 24 for the last command alone
+	for i, want := range []string{"largemem", "standard"} {
+		if got := scripts[i].Conf.Partition; got != want {
+			t.Errorf("script %d on %s, want %s", i, got, want)
+		}
+	}
+}
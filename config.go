@@ -0,0 +1,845 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// --- FLAGS ---
+
+// Single-letter aliases for long flags
+var shortFlags = map[string]string{
+	"I": "input",
+	"O": "output-dir",
+	"L": "logs-dir",
+	"P": "partition",
+	"A": "account",
+	"G": "gres",
+	"C": "cpus",
+	"M": "mem",
+	"T": "time",
+	"E": "email",
+	"J": "job-prefix",
+	"m": "module",
+	"V": "version",
+	"N": "nodes",
+	"n": "ntasks",
+}
+
+// Config holds all Slurm job configuration parameters
+type Config struct {
+	Inputs       inputList
+	OutputDir    string
+	LogsDir      string
+	Partition    string
+	Account      string
+	Gres         string
+	Gpus         string
+	GPUDirective string
+	GPUMonitor   bool
+	GPUPreflight bool
+	CPUs         int
+	Mem          string
+	Time         string
+	Email        string
+	MailType     string
+	NotifySlack  string
+	NotifyURL    string
+	Pushgateway  string
+	Sentinel     bool
+	Meta         bool
+	Provenance   bool
+	NoTimestamps bool
+	JobPrefix    string
+	NameTemplate string
+	Module       moduleList
+	Submit       bool
+	Format       string
+
+	Deterministic  bool
+	Pad            int
+	TrimExt        extList
+	TrimExtReplace bool
+	NoDerive       bool
+	NameFlag       moduleList
+	NameTool       bool
+
+	PrefixSource bool
+	Template     string
+	Sbatch       stringList
+	Throttle     float64
+	Makefile     bool
+	DryRun       dryRunMode
+	Diff         bool
+	Check        bool
+	OnConflict   string
+	Ext          string
+	ChmodX       bool
+	JSON         bool
+	StateDB      string
+	Seff         bool
+	Chunk        int
+	Pack         string
+
+	Nodes           int
+	NTasks          int
+	NTasksPerNode   int
+	InjectThreads   threadsMode
+	Presets         bool
+	MemPerCPU       string
+	MemPerInput     float64
+	TimePerGB       string
+	SUPerCPUHour    float64
+	SUPerGPUHour    float64
+	SUPerGBHour     float64
+	ValidateCluster bool
+
+	QOS              string
+	Constraint       string
+	Reservation      string
+	Licenses         string
+	Exclusive        bool
+	Tmp              string
+	NodeList         string
+	Exclude          string
+	Requeue          string
+	OpenMode         string
+	Hold             bool
+	Nice             int
+	Begin            string
+	Deadline         string
+	Signal           string
+	OnSignal         string
+	Export           string
+	Env              envList
+	Chdir            string
+	CreateChdir      bool
+	JobWorkdir       string
+	StageScratch     bool
+	StageOut         stringList
+	VerifyOutputs    bool
+	Checksums        bool
+	ProfileCmd       bool
+	Cleanup          string
+	Retries          int
+	RetryDelay       int
+	Checkpointable   bool
+	MaxRequeues      int
+	ModulePurge      bool
+	ModuleCollection string
+	Conda            string
+	Micromamba       string
+	SpackEnv         string
+	SpackLoad        moduleList
+	Venv             string
+	Container        string
+	ContainerBind    stringList
+	ContainerCache   string
+	ContainerGPU     string
+	Shell            string
+
+	set           map[string]bool // settings given on the command line or for one job
+	partitionNote string          // why --partition auto chose the partition
+}
+
+// stringList is a repeatable string flag
+type stringList []string
+
+func (l *stringList) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+// repeated marks list flags; the types embedding stringList inherit it
+func (l *stringList) repeated() {}
+
+func (l *stringList) Set(v string) error {
+	// Full slice expression so copies of a Config never share appends
+	*l = append((*l)[:len(*l):len(*l)], v)
+	return nil
+}
+
+// parseMode reads a boolean-style flag that also accepts named modes; true selects the first mode
+func parseMode(name, v string, modes ...string) (string, error) {
+	switch {
+	case v == "true":
+		return modes[0], nil
+	case v == "false" || v == "":
+		return "", nil
+	case slices.Contains(modes, v):
+		return v, nil
+	}
+	return "", fmt.Errorf("%s must be %s, got %q", name, strings.Join(modes, " or "), v)
+}
+
+// dryRunMode is a boolean-style flag that also accepts "summary"
+type dryRunMode string
+
+func (m *dryRunMode) String() string {
+	if m == nil {
+		return ""
+	}
+	return string(*m)
+}
+
+func (m *dryRunMode) Set(v string) error {
+	mode, err := parseMode("dry-run", v, "scripts", "summary")
+	*m = dryRunMode(mode)
+	return err
+}
+
+func (m *dryRunMode) IsBoolFlag() bool { return true }
+
+// threadsMode is a boolean-style flag that also accepts "rewrite"
+type threadsMode string
+
+func (m *threadsMode) String() string {
+	if m == nil {
+		return ""
+	}
+	return string(*m)
+}
+
+func (m *threadsMode) Set(v string) error {
+	mode, err := parseMode("inject-threads", v, "env", "rewrite")
+	*m = threadsMode(mode)
+	return err
+}
+
+func (m *threadsMode) IsBoolFlag() bool { return true }
+
+// envList is a repeatable KEY=VALUE flag of variables exported by the script
+type envList struct{ stringList }
+
+// envNamePattern matches shell variable names
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func (l *envList) Set(v string) error {
+	name, _, ok := strings.Cut(v, "=")
+	if !ok || !envNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid variable %q (use KEY=VALUE)", v)
+	}
+	return l.stringList.Set(v)
+}
+
+// moduleList is a repeatable flag of modules or Spack packages to load that
+// also takes comma-separated lists; a name already in the list is not added again
+type moduleList struct{ stringList }
+
+func (l *moduleList) Set(v string) error {
+	for _, name := range strings.Split(v, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" && !slices.Contains(l.stringList, name) {
+			l.stringList.Set(name)
+		}
+	}
+	return nil
+}
+
+// extList is a moduleList of file extensions, each with a leading dot
+type extList struct{ moduleList }
+
+func (l *extList) Set(v string) error {
+	for _, ext := range strings.Split(v, ",") {
+		if ext = strings.TrimSpace(ext); ext == "" {
+			continue
+		}
+		if err := validateExt(ext); err != nil {
+			return err
+		}
+	}
+	return l.moduleList.Set(v)
+}
+
+// oppositeFlags set the same field, so an explicit one hides defaults for the other
+var oppositeFlags = map[string]string{"requeue": "no-requeue", "no-requeue": "requeue"}
+
+// requeueMode is "requeue", "no-requeue" or empty to leave the cluster default;
+// --requeue and --no-requeue both set it
+type requeueMode struct {
+	mode   *string
+	invert bool
+}
+
+func (m requeueMode) String() string {
+	if m.mode == nil {
+		return ""
+	}
+	return *m.mode
+}
+
+func (m requeueMode) Set(v string) error {
+	on, err := strconv.ParseBool(v)
+	if err != nil {
+		return fmt.Errorf("invalid boolean %q", v)
+	}
+	if on != m.invert {
+		*m.mode = "requeue"
+	} else {
+		*m.mode = "no-requeue"
+	}
+	return nil
+}
+
+func (m requeueMode) IsBoolFlag() bool { return true }
+
+// inputList is a repeatable flag of input files. Each value may be a
+// comma-separated list, and entries with glob patterns are expanded.
+type inputList []string
+
+func (l *inputList) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *inputList) Set(v string) error {
+	for _, entry := range strings.Split(v, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.ContainsAny(entry, "*?[") {
+			*l = append(*l, entry)
+			continue
+		}
+		matches, err := filepath.Glob(entry)
+		if err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", entry, err)
+		}
+		if len(matches) == 0 {
+			return fmt.Errorf("no files match %q", entry)
+		}
+		*l = append(*l, matches...)
+	}
+	return nil
+}
+
+// defaultConfig returns the built-in defaults
+func defaultConfig() Config {
+	return Config{
+		OutputDir: "./Sbatch",
+		LogsDir:   "./Logs",
+		Partition: "standard",
+		CPUs:      1,
+		Mem:       "4G",
+		Time:      "01:00:00",
+		JobPrefix: "job",
+		Pad:       4,
+		MailType:  "BEGIN,END,FAIL",
+
+		OnConflict:   "overwrite",
+		Ext:          ".sbatch",
+		Nodes:        1,
+		NTasks:       1,
+		RetryDelay:   30,
+		ContainerGPU: "nv",
+		Shell:        "bash",
+
+		MaxRequeues: 3,
+	}
+}
+
+// newGenerateFlags registers every generation flag, using the current values of c as defaults
+func newGenerateFlags(c *Config) *flag.FlagSet {
+	fset := flag.NewFlagSet("generate", flag.ExitOnError)
+	fset.Var(&c.Inputs, "input", "Input file with commands, - for stdin; repeatable, comma or glob lists allowed (Required)")
+	fset.StringVar(&c.OutputDir, "output-dir", c.OutputDir, "Output directory for the scripts")
+	fset.StringVar(&c.LogsDir, "logs-dir", c.LogsDir, "Directory for Slurm logs")
+	fset.StringVar(&c.Partition, "partition", c.Partition, "Slurm partition, or auto to pick one from sinfo")
+	fset.StringVar(&c.Account, "account", c.Account, "Slurm account (Required)")
+	fset.Var(checkedString{&c.Gres, validateGres}, "gres", "GRES string, e.g. gpu:2, gpu:a100:2 or a MIG slice like gpu:a100_3g.20gb:1")
+	fset.Var(checkedString{&c.Gpus, validateGpus}, "gpus", "GPUs per node as [type:]count, e.g. a100:2, instead of a raw --gres")
+	fset.Var(checkedString{&c.GPUDirective, validateGPUDirective}, "gpu-directive", "How --gpus is requested: gres (--gres=gpu:...) or gpus-per-node")
+	fset.BoolVar(&c.GPUMonitor, "gpu-monitor", c.GPUMonitor, "Sample GPU utilization and memory with nvidia-smi every minute into a CSV next to the logs")
+	fset.BoolVar(&c.GPUPreflight, "gpu-preflight", c.GPUPreflight, "Fail GPU jobs at once when nvidia-smi is missing or no GPU is visible")
+	fset.StringVar(&c.QOS, "qos", c.QOS, "Quality of service, e.g. long")
+	fset.StringVar(&c.Constraint, "constraint", c.Constraint, "Node features required, e.g. \"skylake|cascadelake\"")
+	fset.StringVar(&c.Reservation, "reservation", c.Reservation, "Run inside this reservation")
+	fset.StringVar(&c.Licenses, "licenses", c.Licenses, "Licenses to reserve, e.g. matlab:1,comsol:2")
+	fset.BoolVar(&c.Exclusive, "exclusive", c.Exclusive, "Allocate whole nodes, not shared with other jobs")
+	fset.StringVar(&c.NodeList, "nodelist", c.NodeList, "Run only on these nodes, e.g. node[01-04]")
+	fset.StringVar(&c.Exclude, "exclude", c.Exclude, "Never run on these nodes, e.g. gpu07")
+	fset.Var(requeueMode{&c.Requeue, false}, "requeue", "Requeue jobs that are preempted or lose their node")
+	fset.Var(requeueMode{&c.Requeue, true}, "no-requeue", "Never requeue jobs, even where the cluster does by default")
+	fset.Var(checkedString{&c.OpenMode, validateOpenMode}, "open-mode", "Log file mode: append (keeps output across requeues) or truncate")
+	fset.BoolVar(&c.Hold, "hold", c.Hold, "Submit jobs held until released with scontrol release")
+	fset.IntVar(&c.Nice, "nice", c.Nice, "Scheduling priority adjustment; positive values yield to other jobs")
+	fset.StringVar(&c.Begin, "begin", c.Begin, "Start no earlier than this time, e.g. 22:00 or now+2hours")
+	fset.StringVar(&c.Deadline, "deadline", c.Deadline, "Remove jobs that cannot finish by this time, e.g. 2024-06-01T08:00")
+	fset.Var(checkedString{&c.Signal, validateSignal}, "signal", "Signal sent before the time limit, e.g. B:USR1@300 to the script 300 s before")
+	fset.StringVar(&c.OnSignal, "on-signal", c.OnSignal, "Shell command the script runs when a B: --signal arrives, e.g. to save a checkpoint")
+	fset.BoolVar(&c.Checkpointable, "checkpointable", c.Checkpointable, "Requeue the job when it is signalled before the time limit or preemption")
+	fset.IntVar(&c.MaxRequeues, "max-requeues", c.MaxRequeues, "Most times a --checkpointable job requeues itself")
+	fset.StringVar(&c.Export, "export", c.Export, "Environment passed to the job: NONE, ALL or a list like ALL,PATH,MYVAR=1")
+	fset.Var(&c.Env, "env", "Variable exported by the script before the command, KEY=VALUE (repeatable)")
+	fset.StringVar(&c.Chdir, "chdir", c.Chdir, "Working directory of the jobs; relative paths are resolved where slurmify runs")
+	fset.BoolVar(&c.CreateChdir, "create-chdir", c.CreateChdir, "Create the --chdir directory in the script if it does not exist")
+	fset.StringVar(&c.JobWorkdir, "job-workdir", c.JobWorkdir, "Run each job in its own directory <dir>/<job name>, with its input files linked in")
+	fset.BoolVar(&c.StageScratch, "stage-scratch", c.StageScratch, "Copy input files to node-local scratch, run there and copy outputs back on exit")
+	fset.Var(&c.StageOut, "stage-out", "Output path copied back by --stage-scratch besides those found in the command (repeatable)")
+	fset.BoolVar(&c.VerifyOutputs, "verify-outputs", c.VerifyOutputs, "Fail jobs whose output file is missing or empty after the command")
+	fset.BoolVar(&c.Checksums, "checksums", c.Checksums, "Write a .sha256 sidecar next to each output of the command")
+	fset.BoolVar(&c.ProfileCmd, "profile-cmd", c.ProfileCmd, "Run the command under /usr/bin/time -v and log its wall time, peak memory and exit code")
+	fset.StringVar(&c.Cleanup, "cleanup", c.Cleanup, "Shell commands the script runs on exit, whether the command succeeds or fails")
+	fset.IntVar(&c.Retries, "retries", c.Retries, "Rerun a failing command up to this many times, e.g. for flaky downloads")
+	fset.IntVar(&c.RetryDelay, "retry-delay", c.RetryDelay, "Seconds before the first retry; the pause doubles after each attempt")
+	fset.Var(checkedString{&c.Tmp, validateMem}, "tmp", "Node-local disk space, e.g. 100G; TMPDIR points to it")
+	fset.Var(countFlag{&c.CPUs}, "cpus", "CPUs per task")
+	fset.Var(countFlag{&c.Nodes}, "nodes", "Number of nodes")
+	fset.Var(countFlag{&c.NTasks}, "ntasks", "Number of tasks (MPI ranks); above 1 the command runs under srun")
+	fset.IntVar(&c.NTasksPerNode, "ntasks-per-node", c.NTasksPerNode, "Tasks per node, instead of a total --ntasks")
+	fset.BoolVar(&c.Presets, "presets", c.Presets, "Apply CPU, memory and time presets for known tools such as bwa, STAR or samtools sort")
+	fset.Float64Var(&c.MemPerInput, "mem-per-input", c.MemPerInput, "Add this many times the size of the command's input files to --mem")
+	fset.Var(checkedString{&c.TimePerGB, validateTime}, "time-per-gb", "Add this walltime per GiB of the command's input files to --time")
+	fset.Float64Var(&c.SUPerCPUHour, "su-per-cpu-hour", c.SUPerCPUHour, "Service units charged per CPU-hour, for cost estimates")
+	fset.Float64Var(&c.SUPerGPUHour, "su-per-gpu-hour", c.SUPerGPUHour, "Service units charged per GPU-hour, for cost estimates")
+	fset.Float64Var(&c.SUPerGBHour, "su-per-gb-hour", c.SUPerGBHour, "Service units charged per GB-hour of memory, for cost estimates")
+	fset.BoolVar(&c.ValidateCluster, "validate-cluster", c.ValidateCluster, "Warn when requests exceed the partition limits reported by scontrol")
+	fset.Var(&c.InjectThreads, "inject-threads", "Export OMP_NUM_THREADS from the allocation; =rewrite also sets -t/--threads/-@/-p values to it")
+	fset.Var(checkedString{&c.Mem, validateMem}, "mem", "Memory per task")
+	fset.Var(checkedString{&c.MemPerCPU, validateMem}, "mem-per-cpu", "Memory per allocated CPU, instead of --mem")
+	fset.Var(checkedString{&c.Time, validateTime}, "time", "Walltime")
+	fset.StringVar(&c.Email, "email", c.Email, "Email for notifications")
+	fset.Var(checkedString{&c.MailType, validateMailType}, "mail-type", "Events that send an email, e.g. FAIL or BEGIN,END,FAIL")
+	fset.StringVar(&c.NotifySlack, "notify-slack", c.NotifySlack, "Slack or Mattermost webhook told when each job ends; a variable name like SLACK_WEBHOOK is read in the job")
+	fset.StringVar(&c.NotifyURL, "notify-url", c.NotifyURL, "URL each job POSTs a JSON summary to when it ends; a variable name is read in the job")
+	fset.StringVar(&c.Pushgateway, "pushgateway", c.Pushgateway, "Prometheus Pushgateway address each job pushes its duration, exit code and peak memory to")
+	fset.BoolVar(&c.Sentinel, "sentinel", c.Sentinel, "Send one batch summary from a job that runs after all others, instead of notifying per job")
+	fset.StringVar(&c.JobPrefix, "job-prefix", c.JobPrefix, "Job name prefix")
+	fset.Var(checkedString{&c.NameTemplate, validateNameTemplate}, "name-template", "Job name layout from {prefix}, {index:03d}, {base}, {tool} and {hash}, e.g. {prefix}_{tool}_{base}")
+	fset.Var(&c.TrimExt, "trim-ext", "Extensions stripped from derived job names besides the built-in ones, e.g. .h5,.loom (repeatable)")
+	fset.BoolVar(&c.TrimExtReplace, "trim-ext-replace", c.TrimExtReplace, "Strip only the --trim-ext extensions, not the built-in genomics ones")
+	fset.Var(&c.NameFlag, "name-flag", "Flag whose value names the command's output, for job names, e.g. --bam (repeatable)")
+	fset.BoolVar(&c.NameTool, "name-tool", c.NameTool, "Put the program a command runs into its job name, e.g. job_bwa_sample1")
+	fset.BoolVar(&c.NoDerive, "no-derive", c.NoDerive, "Name jobs by number only, e.g. job_0007, instead of guessing a name from the command")
+	fset.IntVar(&c.Pad, "pad", c.Pad, "Digits of job numbers in names and files; widened when the batch needs more")
+	fset.Var(&c.Module, "module", "Modules to load, comma-separated or repeated")
+	fset.BoolVar(&c.ModulePurge, "module-purge", c.ModulePurge, "Run module purge before loading modules")
+	fset.StringVar(&c.ModuleCollection, "module-collection", c.ModuleCollection, "Lmod collection to restore with module restore, instead of loading modules one by one")
+	fset.StringVar(&c.Conda, "conda", c.Conda, "Conda environment to activate, by name or path")
+	fset.StringVar(&c.Micromamba, "micromamba", c.Micromamba, "Micromamba environment to activate, by name or path")
+	fset.StringVar(&c.SpackEnv, "spack-env", c.SpackEnv, "Spack environment to activate, by name or path")
+	fset.Var(&c.SpackLoad, "spack-load", "Spack packages to load, comma-separated or repeated")
+	fset.StringVar(&c.Venv, "venv", c.Venv, "Python virtualenv to activate; relative paths are resolved where slurmify runs")
+	fset.StringVar(&c.Container, "container", c.Container, "Apptainer/Singularity image to run the command in, e.g. tools.sif")
+	fset.Var(&c.ContainerBind, "container-bind", "Path to bind into the container, src[:dest[:opts]] (repeatable)")
+	fset.StringVar(&c.ContainerCache, "container-cache", c.ContainerCache, "Shared directory where jobs pull a docker:// --container image once")
+	fset.Var(checkedString{&c.ContainerGPU, validateContainerGPU}, "container-gpu", "GPU support for containers of GPU jobs: nv for NVIDIA or rocm for AMD")
+	fset.Var(checkedString{&c.Shell, validateShell}, "shell", "Interpreter of the scripts: bash, bash-login, env (/usr/bin/env bash), zsh or an absolute path")
+	fset.BoolVar(&c.Submit, "submit", c.Submit, "Submit each generated script with sbatch")
+	fset.StringVar(&c.Format, "format", c.Format, "Input format: text, tsv, csv, yaml or jsonl (default: from the input file extension)")
+	fset.BoolVar(&c.PrefixSource, "prefix-source", c.PrefixSource, "Prefix job names with the name of their input file")
+	fset.StringVar(&c.Template, "template", c.Template, "Go text/template file for the script layout")
+	fset.Var(&c.Sbatch, "sbatch", "Extra #SBATCH directive added verbatim, e.g. --qos=long (repeatable)")
+	fset.Float64Var(&c.Throttle, "throttle", c.Throttle, "Seconds to wait between submissions")
+	fset.Var(&c.DryRun, "dry-run", "Print the scripts instead of writing files; =summary prints one line per job")
+	fset.BoolVar(&c.Diff, "diff", c.Diff, "Show unified diffs against existing scripts instead of writing files")
+	fset.BoolVar(&c.Check, "check", c.Check, "Validate the input and report problems by line without writing files")
+	fset.StringVar(&c.OnConflict, "on-conflict", c.OnConflict, "What to do when a script already exists: overwrite, skip, suffix or error")
+	fset.Var(checkedString{&c.Ext, validateExt}, "ext", "File extension of the scripts, e.g. .sh or .slurm")
+	fset.BoolVar(&c.ChmodX, "chmod-x", c.ChmodX, "Make the scripts executable so they can also be run directly")
+	fset.BoolVar(&c.JSON, "json", c.JSON, "Print the run summary as a JSON document")
+	fset.StringVar(&c.StateDB, "state-db", c.StateDB, "SQLite database recording runs and submissions, e.g. .slurmify/state.db")
+	fset.BoolVar(&c.Seff, "seff", c.Seff, "Append a seff efficiency report to each job's log when it exits")
+	fset.IntVar(&c.Chunk, "chunk", c.Chunk, "Run this many consecutive commands one after another in each script")
+	fset.StringVar(&c.Pack, "pack", c.Pack, "Run chunked commands concurrently: parallel:K with GNU parallel or srun:K as job steps")
+	fset.BoolVar(&c.Makefile, "makefile", c.Makefile, "Also write a Makefile with submit, status and clean-logs targets")
+	fset.BoolVar(&c.Meta, "meta", c.Meta, "Also write a <name>.meta.json sidecar with the command, input line and resources of each script")
+	fset.BoolVar(&c.Provenance, "provenance", c.Provenance, "Start each script with a comment recording the slurmify version, invocation, input checksums and generation time")
+	fset.BoolVar(&c.NoTimestamps, "no-timestamps", c.NoTimestamps, "Leave generation times out of --provenance blocks and --meta sidecars")
+	fset.BoolVar(&c.Deterministic, "deterministic", c.Deterministic, "Byte-identical output from any directory: no times or absolute paths")
+	return fset
+}
+
+// newCommandFlags registers the directory flags shared by the non-generate subcommands
+func newCommandFlags(name string, c *Config) *flag.FlagSet {
+	fset := flag.NewFlagSet(name, flag.ExitOnError)
+	fset.StringVar(&c.OutputDir, "output-dir", c.OutputDir, "Output directory for the scripts")
+	fset.StringVar(&c.LogsDir, "logs-dir", c.LogsDir, "Directory for Slurm logs")
+	fset.Var(checkedString{&c.Ext, validateExt}, "ext", "File extension of the scripts, e.g. .sh or .slurm")
+	fset.StringVar(&c.StateDB, "state-db", c.StateDB, "SQLite database recording runs and submissions, e.g. .slurmify/state.db")
+	return fset
+}
+
+// parseArgs parses a subcommand's flags, then layers profile, environment and
+// config file defaults under anything not given on the command line
+func parseArgs(fset *flag.FlagSet, args []string, synopsis string) error {
+	var profile string
+	fset.StringVar(&profile, "profile", "", "Named profile from the config file")
+	addShortFlags(fset)
+	fset.Usage = func() { printUsage(fset, synopsis) }
+
+	// Flags may follow positional arguments, e.g. "slurmify cmds.txt -A acct"
+	var positional []string
+	for {
+		if err := fset.Parse(args); err != nil {
+			return err
+		}
+		if fset.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fset.Arg(0))
+		args = fset.Args()[1:]
+	}
+	if err := fset.Parse(append([]string{"--"}, positional...)); err != nil {
+		return err
+	}
+
+	fc, err := loadConfigFiles()
+	if err != nil {
+		return err
+	}
+	// Flags > environment > config file, where a profile refines the file's defaults
+	defaults := fc.Defaults
+	if profile != "" {
+		values, err := fc.profile(profile)
+		if err != nil {
+			return err
+		}
+		mergeSettings(defaults, values)
+	}
+	mergeSettings(defaults, envDefaults(fset))
+	return applyDefaults(fset, defaults)
+}
+
+// addShortFlags registers each single-letter alias against its long flag
+func addShortFlags(fset *flag.FlagSet) {
+	for short, long := range shortFlags {
+		if f := fset.Lookup(long); f != nil {
+			fset.Var(f.Value, short, fmt.Sprintf("Alias for --%s", long))
+		}
+	}
+}
+
+// printUsage lists each long flag once, next to its single-letter alias
+func printUsage(fset *flag.FlagSet, synopsis string) {
+	aliases := map[string]string{}
+	for short, long := range shortFlags {
+		aliases[long] = short
+	}
+
+	out := fset.Output()
+	fmt.Fprintf(out, "Usage: %s\n\nFlags:\n", synopsis)
+	fset.VisitAll(func(f *flag.Flag) {
+		if _, ok := shortFlags[f.Name]; ok {
+			return
+		}
+		names := "    --" + f.Name
+		if short, ok := aliases[f.Name]; ok {
+			names = fmt.Sprintf("-%s, --%s", short, f.Name)
+		}
+		typ, usage := flag.UnquoteUsage(f)
+		if typ != "" {
+			names += " " + typ
+		}
+		if f.DefValue != "" && f.DefValue != "false" && f.DefValue != "0" {
+			usage += fmt.Sprintf(" (default %q)", f.DefValue)
+		}
+		fmt.Fprintf(out, "  %s\n    \t%s\n", names, usage)
+	})
+	fmt.Fprintf(out, "\nRun 'slurmify help' to list all commands.\n")
+}
+
+// --- CONFIG FILES ---
+
+// Config files in increasing order of precedence
+const (
+	homeConfigName  = ".slurmify.yaml"
+	localConfigName = "slurmify.yaml"
+)
+
+// envPrefix namespaces environment overrides, e.g. SLURMIFY_ACCOUNT
+const envPrefix = "SLURMIFY_"
+
+// settingAliases lets config files use natural alternate key names
+var settingAliases = map[string]string{
+	"modules": "module",
+}
+
+// noConfigFlags cannot be set from config files or the environment
+var noConfigFlags = map[string]bool{
+	"input": true,
+}
+
+// configPaths lists the config files to load, lowest precedence first
+func configPaths() []string {
+	var paths []string
+	if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(home, homeConfigName))
+	}
+	return append(paths, localConfigName)
+}
+
+// settings maps long flag names to their values; lists are kept as separate
+// entries so repeatable flags receive each one
+type settings map[string][]string
+
+// fileConfig holds the merged contents of all config files
+type fileConfig struct {
+	Defaults settings
+	Profiles map[string]settings
+	Tools    map[string]settings // resource presets by tool, used with --presets
+}
+
+// loadConfigFiles merges all config files, later files overriding earlier ones
+func loadConfigFiles() (fileConfig, error) {
+	// Keys are validated against the full generate flag set
+	known := newGenerateFlags(&Config{})
+
+	merged := fileConfig{Defaults: settings{}, Profiles: map[string]settings{}, Tools: map[string]settings{}}
+	for _, path := range configPaths() {
+		fc, err := readConfigFile(known, path)
+		if err != nil {
+			return merged, err
+		}
+		mergeSettings(merged.Defaults, fc.Defaults)
+		for name, values := range fc.Profiles {
+			if merged.Profiles[name] == nil {
+				merged.Profiles[name] = settings{}
+			}
+			mergeSettings(merged.Profiles[name], values)
+		}
+		for tool, values := range fc.Tools {
+			if merged.Tools[tool] == nil {
+				merged.Tools[tool] = settings{}
+			}
+			mergeSettings(merged.Tools[tool], values)
+		}
+	}
+	return merged, nil
+}
+
+// profile returns the settings of a named profile
+func (fc fileConfig) profile(name string) (settings, error) {
+	settings, ok := fc.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown profile %q", name)
+	}
+	return settings, nil
+}
+
+// envDefaults collects SLURMIFY_* variables for every configurable flag
+func envDefaults(fset *flag.FlagSet) settings {
+	values := settings{}
+	fset.VisitAll(func(f *flag.Flag) {
+		if _, ok := settingFlag(fset, f.Name); !ok {
+			return
+		}
+		env := envPrefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		if v, ok := os.LookupEnv(env); ok && v != "" {
+			values[f.Name] = []string{v}
+		}
+	})
+	return values
+}
+
+// readConfigFile parses one YAML file; a missing file is not an error
+func readConfigFile(fset *flag.FlagSet, path string) (fileConfig, error) {
+	var fc fileConfig
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fc, nil
+	}
+	if err != nil {
+		return fc, fmt.Errorf("could not read config %s: %w", path, err)
+	}
+
+	var raw map[string]any
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return fc, fmt.Errorf("could not parse config %s: %w", path, err)
+	}
+
+	// Profiles and tool presets are nested maps of the same keys
+	if fc.Profiles, err = parseSections(fset, raw, "profiles", path); err != nil {
+		return fc, err
+	}
+	if fc.Tools, err = parseSections(fset, raw, "tools", path); err != nil {
+		return fc, err
+	}
+
+	fc.Defaults, err = parseSettings(fset, raw, path)
+	return fc, err
+}
+
+// parseSections removes a mapping of named settings sections from raw and parses each one
+func parseSections(fset *flag.FlagSet, raw map[string]any, key, path string) (map[string]settings, error) {
+	body, ok := raw[key]
+	if !ok {
+		return nil, nil
+	}
+	delete(raw, key)
+	sections, ok := body.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%s in config %s must be a mapping", key, path)
+	}
+	parsed := make(map[string]settings, len(sections))
+	for name, body := range sections {
+		section, ok := body.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%s entry %q in config %s must be a mapping", key, name, path)
+		}
+		values, err := parseSettings(fset, section, path)
+		if err != nil {
+			return nil, err
+		}
+		parsed[name] = values
+	}
+	return parsed, nil
+}
+
+// parseSettings validates keys and flattens values of one config section
+func parseSettings(fset *flag.FlagSet, raw map[string]any, path string) (settings, error) {
+	values := make(settings, len(raw))
+	for k, v := range raw {
+		name, ok := settingFlag(fset, k)
+		if !ok {
+			return nil, fmt.Errorf("unknown key %q in config %s", k, path)
+		}
+		// A key without a value, such as "qos:", leaves the setting unset
+		if v == nil {
+			continue
+		}
+		values[name] = configValues(v)
+	}
+	return values, nil
+}
+
+// settingFlag resolves a config key such as logs_dir to its long flag name
+func settingFlag(fset *flag.FlagSet, key string) (string, bool) {
+	name := strings.ReplaceAll(strings.ToLower(strings.TrimSpace(key)), "_", "-")
+	if alias, ok := settingAliases[name]; ok {
+		name = alias
+	}
+	if len(name) < 2 || noConfigFlags[name] || fset.Lookup(name) == nil {
+		return "", false
+	}
+	return name, true
+}
+
+// mergeSettings copies src over dst
+func mergeSettings(dst, src settings) {
+	for k, v := range src {
+		dst[k] = v
+	}
+}
+
+// configValues flattens a YAML or JSON value into flag strings; empty list
+// items are dropped
+func configValues(v any) []string {
+	if list, ok := v.([]any); ok {
+		values := make([]string, 0, len(list))
+		for _, item := range list {
+			if item != nil {
+				values = append(values, fmt.Sprint(item))
+			}
+		}
+		return values
+	}
+	return []string{fmt.Sprint(v)}
+}
+
+// applySetting sets a single config key on c, as used by per-command overrides
+func applySetting(c *Config, key string, values ...string) error {
+	fset := newGenerateFlags(c)
+	name, ok := settingFlag(fset, key)
+	if !ok {
+		return fmt.Errorf("unknown setting %q", key)
+	}
+	if batchOnlyFlags[name] {
+		return fmt.Errorf("setting %q applies to the whole batch and cannot be overridden per command", key)
+	}
+	if err := setFlag(fset, name, values); err != nil {
+		return fmt.Errorf("invalid value for %s: %w", key, err)
+	}
+	c.markSet(name)
+
+	// A job switches between --mem and --mem-per-cpu, and between --gres and
+	// --gpus, with either setting
+	switch name {
+	case "mem":
+		c.MemPerCPU = ""
+		delete(c.set, "mem-per-cpu")
+	case "mem-per-cpu":
+		delete(c.set, "mem")
+	case "gpus":
+		c.Gres = "gpu:" + c.Gpus
+	case "gres":
+		c.Gpus = ""
+		delete(c.set, "gpus")
+	}
+	return nil
+}
+
+// markSet records an explicit setting, copying the set so Config copies stay independent
+func (c *Config) markSet(name string) {
+	set := make(map[string]bool, len(c.set)+1)
+	maps.Copy(set, c.set)
+	set[name] = true
+	c.set = set
+}
+
+// explicitFlags lists the long names of the flags passed on the command line
+func explicitFlags(fset *flag.FlagSet) map[string]bool {
+	explicit := map[string]bool{}
+	fset.Visit(func(f *flag.Flag) {
+		if long, ok := shortFlags[f.Name]; ok {
+			explicit[long] = true
+			return
+		}
+		explicit[f.Name] = true
+	})
+	return explicit
+}
+
+// setFlag assigns values to a flag: repeatable flags take each value in turn,
+// other flags take them comma-joined
+func setFlag(fset *flag.FlagSet, name string, values []string) error {
+	f := fset.Lookup(name)
+	if _, ok := f.Value.(interface{ repeated() }); ok {
+		for _, v := range values {
+			if err := f.Value.Set(v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return f.Value.Set(strings.Join(values, ","))
+}
+
+// applyDefaults sets every flag the user did not pass explicitly
+func applyDefaults(fset *flag.FlagSet, defaults settings) error {
+	explicit := explicitFlags(fset)
+
+	names := make([]string, 0, len(defaults))
+	for name := range defaults {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		// Subcommands only take the defaults for flags they define
+		if explicit[name] || explicit[oppositeFlags[name]] || fset.Lookup(name) == nil {
+			continue
+		}
+		if err := setFlag(fset, name, defaults[name]); err != nil {
+			return fmt.Errorf("invalid default for %s: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Every list value is one variable, even when the value is a list in YAML
+func TestListSettingsTakeEachValue(t *testing.T) {
+	input := "jobs:\n  - command: echo hi\n    env: [FOO=1, BAR=2]\n    module: [samtools, bwa]\n"
+	conf := defaultConfig()
+	jobs, err := readJobSpec(strings.NewReader(input), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := jobs[0].Conf
+	if want := []string{"FOO=1", "BAR=2"}; !slices.Equal(c.Env.stringList, want) {
+		t.Errorf("env = %q, want %q", c.Env.stringList, want)
+	}
+	if want := []string{"samtools", "bwa"}; !slices.Equal(c.Module.stringList, want) {
+		t.Errorf("module = %q, want %q", c.Module.stringList, want)
+	}
+	data := newScriptData(jobs[0], "job_hi")
+	if !strings.Contains(data.Setup, "export FOO=\"1\"\nexport BAR=\"2\"\n") {
+		t.Errorf("setup does not export FOO and BAR separately:\n%s", data.Setup)
+	}
+}
+
+func TestCountSettingsRejectZero(t *testing.T) {
+	for _, name := range []string{"cpus", "nodes", "ntasks"} {
+		conf := defaultConfig()
+		if err := applySetting(&conf, name, "0"); err == nil {
+			t.Errorf("%s 0 was accepted", name)
+		}
+	}
+}
+
+// A profile refines the config file, and the environment overrides both
+func TestEnvironmentOverridesProfile(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	conf := "partition: standard\nprofiles:\n  big:\n    partition: largemem\n    qos: long\n"
+	if err := os.WriteFile(localConfigName, []byte(conf), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SLURMIFY_PARTITION", "debug")
+	c := defaultConfig()
+	fset := newGenerateFlags(&c)
+	if err := parseArgs(fset, []string{"--profile", "big"}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if c.Partition != "debug" || c.QOS != "long" {
+		t.Errorf("partition %q, qos %q; want debug from the environment and long from the profile", c.Partition, c.QOS)
+	}
+}
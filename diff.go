@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// --- DIFF ---
+
+// diffContext is the number of unchanged lines shown around each change
+const diffContext = 3
+
+// diffOp is one line of an edit script: ' ' kept, '-' removed, '+' added
+type diffOp struct {
+	kind byte
+	text string
+}
+
+// printDiffs shows how each rendered script differs from the file already on disk
+func printDiffs(w io.Writer, scripts []Script) error {
+	var changed, added, same int
+	for _, s := range scripts {
+		old, err := os.ReadFile(s.Path)
+		oldName := s.Path
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			oldName = "/dev/null"
+			added++
+		case err != nil:
+			return fmt.Errorf("could not read %s: %w", s.Path, err)
+		case string(old) == s.Content:
+			same++
+			continue
+		default:
+			changed++
+		}
+		fmt.Fprintf(w, "--- %s\n+++ %s\n", oldName, s.Path)
+		writeHunks(w, diffLines(splitLines(string(old)), splitLines(s.Content)))
+	}
+	fmt.Fprintf(w, "[slurmify] Diff: %d changed, %d new, %d unchanged\n", changed, added, same)
+	return nil
+}
+
+// splitLines splits content into lines without their newlines
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// diffLines builds an edit script from a longest common subsequence of lines
+func diffLines(a, b []string) []diffOp {
+	// lcs[i][j] is the LCS length of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	return ops
+}
+
+// writeHunks prints the edit script as unified diff hunks
+func writeHunks(w io.Writer, ops []diffOp) {
+	// Line positions in the old and new file before each op
+	oldPos := make([]int, len(ops)+1)
+	newPos := make([]int, len(ops)+1)
+	for k, op := range ops {
+		oldPos[k+1], newPos[k+1] = oldPos[k], newPos[k]
+		if op.kind != '+' {
+			oldPos[k+1]++
+		}
+		if op.kind != '-' {
+			newPos[k+1]++
+		}
+	}
+
+	for k := 0; k < len(ops); {
+		if ops[k].kind == ' ' {
+			k++
+			continue
+		}
+		// Grow the hunk while changes are within two contexts of each other
+		start := max(0, k-diffContext)
+		end := k
+		for end < len(ops) {
+			next := end
+			for next < len(ops) && ops[next].kind == ' ' {
+				next++
+			}
+			if next == len(ops) || next-end > 2*diffContext {
+				break
+			}
+			for next < len(ops) && ops[next].kind != ' ' {
+				next++
+			}
+			end = next
+		}
+		end = min(len(ops), end+diffContext)
+
+		fmt.Fprintf(w, "@@ -%s +%s @@\n",
+			hunkRange(oldPos[start], oldPos[end]-oldPos[start]),
+			hunkRange(newPos[start], newPos[end]-newPos[start]))
+		for _, op := range ops[start:end] {
+			fmt.Fprintf(w, "%c%s\n", op.kind, op.text)
+		}
+		k = end
+	}
+}
+
+// hunkRange formats a hunk's start line and length; empty ranges name the line before
+func hunkRange(pos, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", pos)
+	}
+	return fmt.Sprintf("%d,%d", pos+1, count)
+}
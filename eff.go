@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// --- EFFICIENCY ---
+
+// Headroom added to the observed peaks when suggesting requests for the next run
+const (
+	memHeadroom  = 1.2
+	timeHeadroom = 1.5
+)
+
+// JobUsage is what a finished job requested and actually used
+type JobUsage struct {
+	State   string
+	Elapsed time.Duration
+	Limit   string
+	CPUTime time.Duration
+	CPUs    int
+	MaxRSS  int64 // KiB, peak over all steps
+	ReqMem  int64 // KiB for the whole job
+}
+
+// runEff prints CPU and memory efficiency for a batch and suggests requests for the next run
+func runEff(args []string) error {
+	conf := defaultConfig()
+	fset := newCommandFlags("eff", &conf)
+	if err := parseArgs(fset, args, "slurmify eff [-O <dir>]"); err != nil {
+		return err
+	}
+
+	records, err := loadJobRecords(conf)
+	if err != nil {
+		return fmt.Errorf("could not read job records: %w", err)
+	}
+	if len(records) == 0 {
+		return fmt.Errorf("no submitted jobs recorded in %s/", conf.OutputDir)
+	}
+	ids := make([]string, len(records))
+	for i, r := range records {
+		ids[i] = r.JobID
+	}
+	usage, err := sacctUsage(ids)
+	if err != nil {
+		return err
+	}
+
+	var peakRSS int64
+	var peakElapsed time.Duration
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "JOBID\tSTATE\tELAPSED\tLIMIT\tCPU%\tMAXRSS\tREQMEM\tMEM%\tSCRIPT")
+	for _, r := range records {
+		u, ok := usage[r.JobID]
+		if !ok {
+			fmt.Fprintf(tw, "%s\tUNKNOWN\t-\t-\t-\t-\t-\t-\t%s\n", r.JobID, r.Script)
+			continue
+		}
+		peakRSS = max(peakRSS, u.MaxRSS)
+		peakElapsed = max(peakElapsed, u.Elapsed)
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", r.JobID, u.State, formatTime(u.Elapsed), u.Limit,
+			percent(u.CPUTime.Seconds(), u.Elapsed.Seconds()*float64(u.CPUs)),
+			humanMem(u.MaxRSS), humanMem(u.ReqMem), percent(float64(u.MaxRSS), float64(u.ReqMem)), r.Script)
+	}
+	tw.Flush()
+
+	if peakRSS == 0 || peakElapsed == 0 {
+		fmt.Println("[slurmify] No usage recorded yet; suggestions need finished jobs")
+		return nil
+	}
+	// Round memory up to whole gigabytes and time up to whole minutes
+	mem := int64(math.Ceil(float64(peakRSS)*memHeadroom/(1<<20))) << 20
+	walltime := time.Duration(float64(peakElapsed) * timeHeadroom)
+	walltime = (walltime + time.Minute - 1).Truncate(time.Minute)
+	fmt.Printf("[slurmify] Peak memory %s, peak time %s\n", humanMem(peakRSS), formatTime(peakElapsed))
+	fmt.Printf("[slurmify] Suggested for the next run: --mem %s --time %s\n", formatMem(mem), formatTime(walltime))
+	return nil
+}
+
+// sacctUsage queries sacct for the usage of each job, folding steps into their job
+func sacctUsage(ids []string) (map[string]JobUsage, error) {
+	var stderr strings.Builder
+	cmd := exec.Command("sacct", "-n", "--parsable2",
+		"--format=JobID,State,Elapsed,Timelimit,TotalCPU,AllocCPUS,MaxRSS,ReqMem", "-j", strings.Join(ids, ","))
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("sacct failed: %w: %s", err, msg)
+		}
+		return nil, fmt.Errorf("sacct failed: %w", err)
+	}
+
+	usage := map[string]JobUsage{}
+	for _, line := range strings.Split(string(out), "\n") {
+		f := strings.Split(strings.TrimSpace(line), "|")
+		if len(f) < 8 {
+			continue
+		}
+		jobID, _, isStep := strings.Cut(f[0], ".")
+		u := usage[jobID]
+		u.MaxRSS = max(u.MaxRSS, parseSize(f[6]))
+		if isStep {
+			usage[jobID] = u
+			continue
+		}
+
+		u.State, _, _ = strings.Cut(f[1], " ")
+		u.Elapsed = parseDuration(f[2])
+		u.Limit = f[3]
+		u.CPUTime = parseDuration(f[4])
+		u.CPUs, _ = strconv.Atoi(f[5])
+		// Older Slurm marks ReqMem per node (n) or per CPU (c)
+		req := f[7]
+		perCPU := strings.HasSuffix(req, "c")
+		u.ReqMem = parseSize(strings.TrimRight(req, "nc"))
+		if perCPU {
+			u.ReqMem *= int64(max(u.CPUs, 1))
+		}
+		usage[jobID] = u
+	}
+	return usage, nil
+}
+
+// parseSize reads sacct sizes like 1234K or 2.5G as KiB; bare numbers are bytes
+func parseSize(s string) int64 {
+	if s == "" {
+		return 0
+	}
+	mult := 1.0 / 1024
+	for _, u := range memUnits {
+		if strings.HasSuffix(strings.ToUpper(s), u.suffix) {
+			mult = float64(u.kib)
+			s = s[:len(s)-1]
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0
+	}
+	return int64(math.Ceil(n * mult))
+}
+
+// parseDuration reads sacct times like 1-02:03:04 or 12:34.567, ignoring fractions
+func parseDuration(s string) time.Duration {
+	s, _, _ = strings.Cut(s, ".")
+	d, ok, err := parseTime(s)
+	if err != nil || !ok {
+		return 0
+	}
+	return d
+}
+
+// humanMem shows KiB in the largest unit that keeps a value of at least 1, e.g. 2.9G
+func humanMem(kib int64) string {
+	for _, u := range memUnits {
+		if kib >= u.kib {
+			v := float64(kib) / float64(u.kib)
+			if v == math.Trunc(v) {
+				return fmt.Sprintf("%.0f%s", v, u.suffix)
+			}
+			return fmt.Sprintf("%.1f%s", v, u.suffix)
+		}
+	}
+	return "0"
+}
+
+// percent formats part/whole, or "-" when there is no whole
+func percent(part, whole float64) string {
+	if whole <= 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.0f%%", 100*part/whole)
+}
@@ -0,0 +1,679 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// --- GENERATE ---
+
+const generateSynopsis = "slurmify [generate] -I <commands.txt|-> -A <account> [flags]"
+
+// runGenerate writes one script per command and optionally submits them
+func runGenerate(args []string) error {
+	conf := defaultConfig()
+	fset := newGenerateFlags(&conf)
+
+	var showVersion bool
+	fset.BoolVar(&showVersion, "version", false, "Show version and exit")
+
+	if err := parseArgs(fset, args, generateSynopsis); err != nil {
+		return err
+	}
+
+	if showVersion {
+		fmt.Printf("Slurmify %s\n", version)
+		return nil
+	}
+
+	// Positional arguments are inputs too; piped stdin is used when there are none
+	for _, arg := range fset.Args() {
+		if err := conf.Inputs.Set(arg); err != nil {
+			return err
+		}
+	}
+	if len(conf.Inputs) == 0 && stdinPiped() {
+		conf.Inputs = inputList{"-"}
+	}
+
+	if len(conf.Inputs) == 0 || conf.Account == "" {
+		return fmt.Errorf("error: required flags -I/--input and -A/--account are missing")
+	}
+
+	if !slices.Contains(conflictPolicies, conf.OnConflict) {
+		return fmt.Errorf("unknown --on-conflict policy %q (use %s)", conf.OnConflict, strings.Join(conflictPolicies, ", "))
+	}
+
+	conf.set = explicitFlags(fset)
+	if err := resolveMem(&conf); err != nil {
+		return err
+	}
+	if err := resolveGPUs(&conf); err != nil {
+		return err
+	}
+	presets, err := loadPresets()
+	if err != nil {
+		return err
+	}
+
+	// --check reports the problems of the input with those of the jobs
+	jobs, skipped, inputErr := loadJobs(conf, presets)
+	if inputErr != nil && !conf.Check {
+		return inputErr
+	}
+	if conf.Check {
+		return checkJobs(os.Stdout, jobs, conf, inputErr)
+	}
+
+	scripts, err := renderScripts(jobs, conf)
+	if err != nil {
+		return err
+	}
+
+	rep := &report{quiet: conf.JSON, Skipped: skipped}
+	if conf.ValidateCluster {
+		validateCluster(scripts, rep)
+	}
+	for _, s := range scripts {
+		if s.Supersedes != "" {
+			rep.warnf("%s exists with other content; the job is written to %s instead", s.Supersedes, s.Path)
+		}
+		for _, job := range s.Jobs {
+			if job.Conf.VerifyOutputs && len(checkedOutputs(job.Command)) == 0 {
+				rep.warnf("%s: line %d: --verify-outputs found no output file in the command", sourceName(job.Source), job.Line)
+			}
+		}
+	}
+
+	if conf.DryRun != "" {
+		printDryRun(os.Stdout, scripts, conf)
+		return nil
+	}
+	if conf.Diff {
+		return printDiffs(os.Stdout, scripts)
+	}
+
+	rep.OutputDir = conf.OutputDir
+	rep.LogsDir = conf.LogsDir
+
+	// Setup directories
+	if err := os.MkdirAll(conf.OutputDir, 0755); err != nil {
+		return fmt.Errorf("could not create output directory: %w", err)
+	}
+	if err := os.MkdirAll(conf.LogsDir, 0755); err != nil {
+		return fmt.Errorf("could not create logs directory: %w", err)
+	}
+
+	// Write scripts
+	scripts = writeScripts(scripts, rep)
+
+	rep.Unchanged = countScripts(scripts, func(s Script) bool { return s.Same })
+	rep.Kept = countScripts(scripts, func(s Script) bool { return s.Keep }) - rep.Unchanged
+	rep.Generated = len(scripts) - rep.Kept - rep.Unchanged
+	rep.printf("[slurmify] Generated %d script(s) in %s/\n", rep.Generated, conf.OutputDir)
+	if rep.Unchanged > 0 {
+		rep.printf("[slurmify] %d script(s) unchanged\n", rep.Unchanged)
+	}
+	if rep.Kept > 0 {
+		rep.printf("[slurmify] Kept %d existing script(s)\n", rep.Kept)
+	}
+	rep.printf("[slurmify] Logs destination in %s/\n", conf.LogsDir)
+	rep.Usage = batchUsage(scripts)
+	rep.printf("%s", rep.Usage)
+
+	if len(scripts) > 0 {
+		if conf.Sentinel {
+			if rep.Sentinel, err = writeSentinel(conf); err != nil {
+				return err
+			}
+		}
+		if rep.SubmitWrapper, err = writeSubmitWrapper(conf, scripts, rep.Sentinel); err != nil {
+			return err
+		}
+		rep.printf("[slurmify] Submit the batch with %s\n", rep.SubmitWrapper)
+
+		if rep.Manifest, err = writeManifest(conf.OutputDir, scripts); err != nil {
+			return err
+		}
+	}
+
+	if conf.Meta {
+		if err := writeMetadata(scripts, generatedAt(conf)); err != nil {
+			return err
+		}
+	}
+
+	if conf.Makefile && len(scripts) > 0 {
+		if rep.Makefile, err = writeMakefile(conf, scripts); err != nil {
+			return err
+		}
+		rep.printf("[slurmify] Drive the batch with make -C %s submit\n", filepath.Dir(rep.Makefile))
+	}
+
+	var runID int64
+	if conf.StateDB != "" {
+		if runID, err = recordState(conf.StateDB, conf.OutputDir, scripts); err != nil {
+			return err
+		}
+	}
+
+	if conf.Submit {
+		subs := submitScripts(scripts, conf.Throttle)
+		rep.addSubmissions(subs)
+		if err := recordAll(conf, runID, subs); err != nil {
+			return err
+		}
+		if rep.Sentinel != "" {
+			if rep.SentinelJobID, err = submitSentinel(rep.Sentinel, subs); err != nil {
+				rep.warnf("Could not submit the sentinel job: %v", err)
+			} else {
+				rep.printf("[slurmify] Submitted sentinel %s as job %s; it reports when the batch is done\n", rep.Sentinel, rep.SentinelJobID)
+			}
+		}
+		if held := heldJobIDs(scripts, subs); len(held) > 0 {
+			rep.printf("[slurmify] %d job(s) are held; release them with scontrol release %s\n", len(held), strings.Join(held, ","))
+		}
+	}
+
+	if conf.JSON {
+		return rep.writeJSON(os.Stdout)
+	}
+	return nil
+}
+
+// report is the outcome of a generate run. It is printed as the run goes,
+// or collected and written as one JSON document with --json.
+type report struct {
+	quiet bool
+
+	OutputDir     string         `json:"output_dir"`
+	LogsDir       string         `json:"logs_dir"`
+	Generated     int            `json:"generated"`
+	Unchanged     int            `json:"unchanged"`
+	Kept          int            `json:"kept"`
+	Failed        int            `json:"failed"`
+	Scripts       []scriptReport `json:"scripts"`
+	SubmitWrapper string         `json:"submit_wrapper,omitempty"`
+	Manifest      string         `json:"manifest,omitempty"`
+	Makefile      string         `json:"makefile,omitempty"`
+	Sentinel      string         `json:"sentinel,omitempty"`
+	SentinelJobID string         `json:"sentinel_job_id,omitempty"`
+	Usage         usage          `json:"usage"`
+	Submitted     []submitReport `json:"submitted,omitempty"`
+	Warnings      []string       `json:"warnings"`
+	Skipped       []skippedLine  `json:"skipped"`
+}
+
+// scriptReport is one script of a report; Status is written, unchanged or kept
+type scriptReport struct {
+	Name   string `json:"name"`
+	Path   string `json:"path"`
+	Source string `json:"source"`
+	Line   int    `json:"line"`
+	Status string `json:"status"`
+
+	Supersedes string `json:"supersedes,omitempty"`
+}
+
+// submitReport is one sbatch call of a report
+type submitReport struct {
+	Script string `json:"script"`
+	JobID  string `json:"job_id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// printf prints progress unless the report is written as JSON
+func (r *report) printf(format string, args ...any) {
+	if !r.quiet {
+		fmt.Printf(format, args...)
+	}
+}
+
+// warnf records a warning and prints it to stderr unless the report is written as JSON
+func (r *report) warnf(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	r.Warnings = append(r.Warnings, msg)
+	if !r.quiet {
+		fmt.Fprintf(os.Stderr, "[slurmify] Warning: %s\n", msg)
+	}
+}
+
+// addScript records a script that is on disk after the run
+func (r *report) addScript(s Script) {
+	r.Scripts = append(r.Scripts, scriptReport{Name: s.Name, Path: s.Path, Source: sourceName(s.Job.Source), Line: s.Job.Line, Status: scriptStatus(s), Supersedes: s.Supersedes})
+}
+
+// scriptStatus describes what the run did with a script: written, unchanged or kept
+func scriptStatus(s Script) string {
+	switch {
+	case s.Same:
+		return "unchanged"
+	case s.Keep:
+		return "kept"
+	}
+	return "written"
+}
+
+// addSubmissions records sbatch results, printing them unless the report is written as JSON
+func (r *report) addSubmissions(subs []Submission) {
+	if !r.quiet {
+		printSubmissions(subs)
+	}
+	for _, s := range subs {
+		sub := submitReport{Script: s.Script, JobID: s.JobID}
+		if s.Err != nil {
+			sub.Error = s.Err.Error()
+			r.Warnings = append(r.Warnings, fmt.Sprintf("Could not submit %s: %v", s.Script, s.Err))
+		}
+		r.Submitted = append(r.Submitted, sub)
+	}
+}
+
+// writeJSON writes the report as an indented JSON document
+func (r *report) writeJSON(w io.Writer) error {
+	if r.Scripts == nil {
+		r.Scripts = []scriptReport{}
+	}
+	if r.Warnings == nil {
+		r.Warnings = []string{}
+	}
+	if r.Skipped == nil {
+		r.Skipped = []skippedLine{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// Script is a generated .sbatch file and the job it came from
+type Script struct {
+	Path    string
+	Name    string
+	Index   int    // position of the job in the batch
+	After   []int  // indexes of jobs that must finish successfully first
+	Job     Job    // the first job of the script
+	Jobs    []Job  // every job the script runs, more than one with --chunk
+	Conf    Config // resources as requested, with packed CPUs multiplied out
+	Content string
+	Keep    bool   // an existing file is used as is
+	Same    bool   // the existing file already has this content
+	Shared  string // the name this job shared with others in the batch, if it was renamed
+
+	Supersedes string // the existing script a numbered copy was written next to
+}
+
+// openInput opens the command source, treating "-" as standard input
+func openInput(path string) (io.ReadCloser, error) {
+	if path == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open input file: %w", err)
+	}
+	return file, nil
+}
+
+// stdinPiped reports whether stdin is a pipe or file rather than a terminal
+func stdinPiped() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice == 0
+}
+
+// loadJobs reads every input in order, each parsed as its own group. The jobs
+// that could be read are returned along with the problems of every input.
+func loadJobs(conf Config, presets map[string]settings) ([]Job, []skippedLine, error) {
+	var jobs []Job
+	var skipped []skippedLine
+	var errs []error
+	for _, path := range conf.Inputs {
+		group, skips, err := loadInput(path, conf, presets)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		jobs = append(jobs, group...)
+		skipped = append(skipped, skips...)
+	}
+	return jobs, skipped, errors.Join(errs...)
+}
+
+// loadInput parses one input; with --prefix-source its jobs are namespaced by the file name
+func loadInput(path string, conf Config, presets map[string]settings) ([]Job, []skippedLine, error) {
+	input, err := openInput(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer input.Close()
+
+	jobs, skipped, err := readJobs(input, inputFormat(path, conf.Format), conf)
+	for i := range skipped {
+		skipped[i].Source = sourceName(path)
+	}
+	var errs []error
+	if err != nil {
+		for _, e := range splitErrors(err) {
+			if le, ok := e.(*lineError); ok {
+				le.Source = path
+			} else {
+				e = fmt.Errorf("%s: %w", sourceName(path), e)
+			}
+			errs = append(errs, e)
+		}
+	}
+	for i := range jobs {
+		jobs[i].Source = path
+		if conf.PrefixSource {
+			jobs[i].Group = sourceStem(path)
+		}
+		if err := applyPreset(&jobs[i], presets); err != nil {
+			errs = append(errs, &lineError{Source: path, Line: jobs[i].Line, Err: err})
+		}
+		if err := applyInputScaling(&jobs[i].Conf, jobs[i].Command); err != nil {
+			errs = append(errs, &lineError{Source: path, Line: jobs[i].Line, Err: err})
+		}
+	}
+	return jobs, skipped, errors.Join(errs...)
+}
+
+// generatedAt is the time stamped into provenance and metadata; zero leaves it
+// out with --no-timestamps or --deterministic
+func generatedAt(conf Config) time.Time {
+	if conf.NoTimestamps || conf.Deterministic {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// renderScripts builds the content and file name of every script without writing them
+func renderScripts(jobs []Job, conf Config) ([]Script, error) {
+	// Resolve names up front so dependencies are checked before anything is written.
+	// A dependency names an earlier job or the input line number of one; a
+	// repeated name refers to the most recent job with it.
+	names := make([]string, len(jobs))
+	after := make([][]int, len(jobs))
+	byName := map[string]int{}
+	byLine := map[string]int{}
+	width := indexWidth(conf.Pad, len(jobs))
+	for i, job := range jobs {
+		if err := checkShell(job.Conf); err != nil {
+			return nil, fmt.Errorf("%s: line %d: %w", sourceName(job.Source), job.Line, err)
+		}
+		names[i] = job.Name
+		if names[i] == "" {
+			names[i] = deriveJobName(job.Command, job.Conf, i+1, width)
+		}
+		names[i] = slurmName(qualifyName(job.Group, names[i]))
+		if names[i] == "" {
+			names[i] = job.Conf.JobPrefix + "_" + padIndex(i+1, width)
+		}
+		for _, dep := range job.After {
+			idx, ok := byLine[job.Source+":"+dep]
+			if !ok {
+				idx, ok = byName[slurmName(qualifyName(job.Group, dep))]
+			}
+			if !ok {
+				return nil, fmt.Errorf("%s: line %d: dependency %q does not name an earlier job", sourceName(job.Source), job.Line, dep)
+			}
+			if !slices.Contains(after[i], idx) {
+				after[i] = append(after[i], idx)
+			}
+		}
+		byName[names[i]] = i
+		byLine[fmt.Sprintf("%s:%d", job.Source, job.Line)] = i
+	}
+	shared := dedupeNames(names, jobs)
+
+	tmpl, err := loadTemplate(conf.Template)
+	if err != nil {
+		return nil, err
+	}
+
+	// --diff compares against the names a regeneration into an empty directory would use
+	policy := conf.OnConflict
+	if conf.Diff {
+		policy = "overwrite"
+	}
+
+	// With --chunk or --pack, consecutive jobs share a script and dependencies
+	// move to their chunks. Packing defaults to one chunk per concurrent wave.
+	pack, err := parsePack(conf.Pack)
+	if err != nil {
+		return nil, err
+	}
+	size := conf.Chunk
+	if pack.Mode != "" && size == 0 {
+		size = pack.Width
+	}
+	groups := chunkJobs(size, after, pack.Mode != "")
+	if err := selectPartitions(jobs, groups, pack); err != nil {
+		return nil, err
+	}
+	scriptOf := make([]int, len(jobs))
+	for g, members := range groups {
+		for _, i := range members {
+			scriptOf[i] = g
+		}
+	}
+
+	var prov *provenance
+	if conf.Provenance {
+		prov = newProvenance(conf)
+	}
+
+	taken := map[string]bool{}
+	scripts := make([]Script, 0, len(groups))
+	for g, members := range groups {
+		first := jobs[members[0]]
+		name := names[members[0]]
+		var deps []int
+		for _, i := range members {
+			for _, dep := range after[i] {
+				if d := scriptOf[dep]; d != g && !slices.Contains(deps, d) {
+					deps = append(deps, d)
+				}
+			}
+		}
+
+		data := newScriptData(first, name)
+		if len(members) > 1 {
+			name = slurmName(qualifyName(first.Group, first.Conf.JobPrefix+"_chunk"+padIndex(g+1, width)))
+			if data, err = newChunkData(jobs, members, name, pack); err != nil {
+				return nil, err
+			}
+		}
+		chunk := make([]Job, len(members))
+		for k, i := range members {
+			chunk[k] = jobs[i]
+		}
+		if prov != nil {
+			data.Provenance = prov.block(chunk)
+		}
+		content, err := generateScript(data, tmpl)
+		if err != nil {
+			return nil, err
+		}
+		filename, keep, err := resolveFilename(conf.OutputDir, name, conf.Ext, padIndex(g+1, width), taken, policy, content)
+		if err != nil {
+			return nil, err
+		}
+		// A numbered copy under --on-conflict suffix leaves the old script beside it
+		supersedes := ""
+		if plain := filepath.Join(conf.OutputDir, name+conf.Ext); filename != plain && !taken[plain] && fileExists(plain) {
+			supersedes = plain
+		}
+		taken[filename] = true
+
+		scripts = append(scripts, Script{Path: filename, Name: name, Shared: shared[members[0]], Index: g, After: deps, Job: first, Jobs: chunk,
+			Conf: data.Config, Content: content, Keep: keep, Same: keep && sameContent(filename, content), Supersedes: supersedes})
+	}
+	return scripts, nil
+}
+
+// chunkJobs splits job indexes into runs of size consecutive jobs; size below 2
+// keeps one job per script. Packed commands run at the same time, so with packed
+// a job that depends on one already in the chunk, including across a stage
+// boundary, starts the next chunk.
+func chunkJobs(size int, after [][]int, packed bool) [][]int {
+	size = max(size, 1)
+	var groups [][]int
+	var group []int
+	for i := range after {
+		full := len(group) == size
+		if packed && len(group) > 0 && slices.ContainsFunc(after[i], func(dep int) bool { return dep >= group[0] }) {
+			full = true
+		}
+		if full {
+			groups = append(groups, group)
+			group = nil
+		}
+		group = append(group, i)
+	}
+	if len(group) > 0 {
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// writeScripts writes every rendered script and returns the ones now on disk
+func writeScripts(scripts []Script, rep *report) []Script {
+	written := scripts[:0]
+	for _, s := range scripts {
+		if !s.Keep || s.Same {
+			if err := writeScript(s); err != nil {
+				rep.warnf("Could not write %s: %v", s.Path, err)
+				rep.Failed++
+				continue
+			}
+		}
+		rep.addScript(s)
+		written = append(written, s)
+	}
+	return written
+}
+
+// writeScript writes a script unless it is unchanged; with --chmod-x it is made
+// executable, including when an existing file is kept or overwritten
+func writeScript(s Script) error {
+	if !s.Conf.ChmodX {
+		return writeIfChanged(s.Path, s.Content, 0644)
+	}
+	if err := writeIfChanged(s.Path, s.Content, 0755); err != nil {
+		return err
+	}
+	return os.Chmod(s.Path, 0755)
+}
+
+// printDryRun shows what would be written, either in full or one line per job
+func printDryRun(w io.Writer, scripts []Script, conf Config) {
+	if conf.DryRun == "summary" {
+		// Per-script cost is shown when a charging model is configured
+		costs := batchUsage(scripts).charged
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprint(tw, "SCRIPT\tPARTITION\tCPUS\tMEM\tTIME\tGRES\tAFTER")
+		if costs {
+			fmt.Fprint(tw, "\tSU")
+		}
+		fmt.Fprintln(tw)
+		for _, s := range scripts {
+			c := s.Job.Conf
+			deps := make([]string, len(s.After))
+			for i, idx := range s.After {
+				deps[i] = scriptStem(scripts[idx].Path)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\t%s", s.Path, c.Partition, c.CPUs, memRequest(c), c.Time,
+				orDash(c.Gres), orDash(strings.Join(deps, ",")))
+			if costs {
+				fmt.Fprintf(tw, "\t%s", formatHours(scriptUsage(s.Conf).SU))
+			}
+			fmt.Fprintln(tw)
+		}
+		tw.Flush()
+	} else {
+		for _, s := range scripts {
+			fmt.Fprintf(w, "# ==> %s <==\n%s\n", s.Path, s.Content)
+		}
+	}
+	fmt.Fprintf(w, "[slurmify] Dry run: %d script(s) would be written to %s/\n", len(scripts), conf.OutputDir)
+	fmt.Fprint(w, batchUsage(scripts))
+}
+
+// orDash shows empty table cells as "-"
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// Policies for a script that already exists on disk
+var conflictPolicies = []string{"overwrite", "skip", "suffix", "error"}
+
+// resolveFilename handles collisions: with this batch by suffixing the padded
+// script number, with existing files by policy. It reports whether the existing file is kept;
+// an existing file with identical content is always kept, unchanged.
+func resolveFilename(dir, jobName, ext, number string, taken map[string]bool, policy, content string) (string, bool, error) {
+	filename := filepath.Join(dir, jobName+ext)
+	suffixed := filepath.Join(dir, jobName+"_"+number+ext)
+	if taken[filename] {
+		filename = suffixed
+	}
+	if !fileExists(filename) {
+		return filename, false, nil
+	}
+	if sameContent(filename, content) {
+		return filename, true, nil
+	}
+
+	switch policy {
+	case "overwrite":
+		return filename, false, nil
+	case "skip":
+		return filename, true, nil
+	case "error":
+		return "", false, fmt.Errorf("%s already exists (see --on-conflict)", filename)
+	}
+	// Numbered names are replaced; suffixing again would never converge
+	if filename != suffixed && sameContent(suffixed, content) {
+		return suffixed, true, nil
+	}
+	return suffixed, false, nil
+}
+
+// sameContent reports whether the file at path holds exactly content
+func sameContent(path, content string) bool {
+	data, err := os.ReadFile(path)
+	return err == nil && string(data) == content
+}
+
+// writeIfChanged writes content unless the file already holds it, keeping its mtime
+func writeIfChanged(path, content string, perm os.FileMode) error {
+	if sameContent(path, content) {
+		return nil
+	}
+	return os.WriteFile(path, []byte(content), perm)
+}
+
+// countScripts counts the scripts matching keep
+func countScripts(scripts []Script, keep func(Script) bool) int {
+	n := 0
+	for _, s := range scripts {
+		if keep(s) {
+			n++
+		}
+	}
+	return n
+}
+
+// fileExists reports whether a file is present at path
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
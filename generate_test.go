@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Packed commands run at the same time, so no chunk may hold a job and its dependency
+func TestPackedChunksSplitAtDependencies(t *testing.T) {
+	input := "echo a > a1\necho b > b2\necho a > a3\n@after 3\ncat a3 > c1\n## stage: two\necho d > d6\necho e > e7\n"
+	for _, pack := range []string{"parallel:2", "srun:2"} {
+		conf := defaultConfig()
+		conf.Account = "acct"
+		conf.Pack = pack
+		jobs, _, err := readCommands(strings.NewReader(input), conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scripts, err := renderScripts(jobs, conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Dependencies, including those of a stage, are input line numbers here
+		for _, s := range scripts {
+			for _, job := range s.Jobs {
+				for _, other := range s.Jobs {
+					if slices.Contains(job.After, strconv.Itoa(other.Line)) {
+						t.Errorf("%s: %s packs line %d with its dependency on line %d", pack, s.Path, job.Line, other.Line)
+					}
+				}
+			}
+		}
+		// Lines 1-2, line 3, line 5, then the stage of lines 7-8
+		if len(scripts) != 4 {
+			t.Errorf("%s: got %d scripts, want 4", pack, len(scripts))
+		}
+	}
+}
+
+func TestChunkJobsKeepsSequentialChunks(t *testing.T) {
+	after := [][]int{nil, {0}, nil, nil}
+	got := chunkJobs(2, after, false)
+	want := [][]int{{0, 1}, {2, 3}}
+	if !slices.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("chunkJobs = %v, want %v", got, want)
+	}
+	got = chunkJobs(2, after, true)
+	want = [][]int{{0}, {1, 2}, {3}}
+	if !slices.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("packed chunkJobs = %v, want %v", got, want)
+	}
+}
+
+// The original comment shows the input line, including an explicit job name
+func TestOriginalKeepsNamedLines(t *testing.T) {
+	input := "align :: bwa mem ref.fa r.fq\n[sort] samtools sort x.bam\n"
+	for _, chunk := range []int{0, 2} {
+		conf := defaultConfig()
+		conf.Account = "acct"
+		conf.Chunk = chunk
+		jobs, _, err := readCommands(strings.NewReader(input), conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scripts, err := renderScripts(jobs, conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var content string
+		for _, s := range scripts {
+			content += s.Content
+		}
+		for _, line := range strings.Split(strings.TrimSpace(input), "\n") {
+			if !strings.Contains(content, "# original: "+line+"\n") {
+				t.Errorf("chunk %d: no original comment for %q", chunk, line)
+			}
+		}
+	}
+}
+
+// Sequential chunk members launch their tasks with srun like single jobs do
+func TestSequentialChunkKeepsSrun(t *testing.T) {
+	conf := defaultConfig()
+	conf.Account = "acct"
+	conf.Chunk = 2
+	conf.NTasks = 4
+	jobs, _, err := readCommands(strings.NewReader("mpi_a in1\nmpi_b in2\n"), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scripts, err := renderScripts(jobs, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(scripts[0].Content, "\nsrun "); n != 2 {
+		t.Errorf("chunk launches %d commands with srun, want 2:\n%s", n, scripts[0].Content)
+	}
+}
+
+// Exported bash functions cannot run under zsh, so generation refuses them
+func TestZshRefusesBashFunctions(t *testing.T) {
+	conf := defaultConfig()
+	conf.Account = "acct"
+	conf.Shell = "zsh"
+	conf.Retries = 2
+	jobs, _, err := readCommands(strings.NewReader("echo hi\n"), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := renderScripts(jobs, conf); err == nil {
+		t.Error("zsh scripts with --retries were rendered")
+	}
+}
+
+// --deterministic output depends only on the inputs, not on where slurmify runs
+func TestDeterministicOutputIgnoresWorkingDirectory(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	generate := func() map[string][]byte {
+		dir := filepath.Join(t.TempDir(), "project")
+		for _, sub := range []string{"data", "env/bin", "images"} {
+			if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		t.Chdir(dir)
+		files := map[string]string{
+			"commands.txt":    "sort data/in.txt > data/out.txt\n@after 1\nwc -l data/out.txt\n",
+			"data/in.txt":     "b\na\n",
+			"images/tool.sif": "",
+		}
+		for name, content := range files {
+			if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		err := runGenerate([]string{"-I", "commands.txt", "-A", "acct", "-O", "out", "-L", "logs",
+			"--deterministic", "--provenance", "--meta", "--makefile", "--chdir", "work", "--create-chdir",
+			"--venv", "env", "--job-workdir", "scratch", "--container", "images/tool.sif"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := map[string][]byte{}
+		entries, err := os.ReadDir("out")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range entries {
+			data, err := os.ReadFile(filepath.Join("out", entry.Name()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(data, []byte(dir)) {
+				t.Errorf("%s names the working directory %s", entry.Name(), dir)
+			}
+			out[entry.Name()] = data
+		}
+		return out
+	}
+
+	first, second := generate(), generate()
+	if len(first) == 0 || len(first) != len(second) {
+		t.Fatalf("generated %d files, then %d", len(first), len(second))
+	}
+	for name, data := range first {
+		if !bytes.Equal(data, second[name]) {
+			t.Errorf("%s differs between runs:\n%s\n---\n%s", name, data, second[name])
+		}
+	}
+}
@@ -0,0 +1,549 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/shlex"
+	"gopkg.in/yaml.v3"
+)
+
+// --- INPUT PARSING ---
+
+// directivePrefix marks a comment line carrying overrides for the next command
+const directivePrefix = "#slurmify"
+
+// afterPrefix marks a line listing the jobs the next command depends on
+const afterPrefix = "@after"
+
+// Explicit job names on a command line, "name :: command" or "[name] command"
+var (
+	namedPattern   = regexp.MustCompile(`^([A-Za-z0-9_.+-]+)\s+::\s+(\S.*)$`)
+	bracketPattern = regexp.MustCompile(`^\[([A-Za-z0-9_.+-]+)\]\s+(\S.*)$`)
+)
+
+// stagePattern matches section headers such as "## stage: align"
+var stagePattern = regexp.MustCompile(`^##\s*stage:\s*(\S+)\s*$`)
+
+// batchOnlyFlags apply to the whole run and cannot be overridden per command
+var batchOnlyFlags = map[string]bool{
+	"output-dir":       true,
+	"logs-dir":         true,
+	"submit":           true,
+	"format":           true,
+	"prefix-source":    true,
+	"template":         true,
+	"throttle":         true,
+	"makefile":         true,
+	"dry-run":          true,
+	"diff":             true,
+	"check":            true,
+	"on-conflict":      true,
+	"ext":              true,
+	"pad":              true,
+	"chmod-x":          true,
+	"json":             true,
+	"state-db":         true,
+	"chunk":            true,
+	"pack":             true,
+	"validate-cluster": true,
+	"sentinel":         true,
+	"meta":             true,
+	"provenance":       true,
+	"no-timestamps":    true,
+	"deterministic":    true,
+}
+
+// Job is a single command resolved against its effective configuration
+type Job struct {
+	Line    int
+	Command string
+	Raw     string   // input line as written, before the name was split off
+	Name    string   // explicit job name, bypasses derivation
+	After   []string // job names that must finish successfully first
+	Group   string   // namespace for names and dependencies, from --prefix-source
+	Source  string   // input path the job was read from
+	Stage   string   // section of a staged input file
+	Conf    Config
+}
+
+// lineError is a problem with one line of an input. Readers collect them and
+// keep going, so that --check reports every bad line at once.
+type lineError struct {
+	Source string // input path, set once the reader is done
+	Line   int
+	Err    error
+}
+
+func (e *lineError) Error() string {
+	msg := fmt.Sprintf("line %d: %v", e.Line, e.Err)
+	if e.Source != "" {
+		msg = sourceName(e.Source) + ": " + msg
+	}
+	return msg
+}
+
+func (e *lineError) Unwrap() error { return e.Err }
+
+// skippedLine is an input line that holds no job, listed in the --json report
+type skippedLine struct {
+	Source string `json:"source"`
+	Line   int    `json:"line"`
+	Reason string `json:"reason"`
+}
+
+// skipReason says why a line without a job or directive was skipped
+func skipReason(line string) string {
+	if strings.TrimSpace(line) == "" {
+		return "blank line"
+	}
+	return "comment"
+}
+
+// splitErrors lists the errors joined into err, at any depth
+func splitErrors(err error) []error {
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return []error{err}
+	}
+	var errs []error
+	for _, e := range joined.Unwrap() {
+		errs = append(errs, splitErrors(e)...)
+	}
+	return errs
+}
+
+// original is the job as the input wrote it; formats without a raw line
+// fall back to the command
+func (j Job) original() string {
+	if j.Raw != "" {
+		return j.Raw
+	}
+	return j.Command
+}
+
+// inputFormat picks the parser from --format or the input file extension
+func inputFormat(path, format string) string {
+	if format != "" {
+		return strings.ToLower(format)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".tsv":
+		return "tsv"
+	case ".csv":
+		return "csv"
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".jsonl", ".ndjson":
+		return "jsonl"
+	}
+	return "text"
+}
+
+// readJobs parses one input in the given format, also returning the lines it skipped
+func readJobs(r io.Reader, format string, conf Config) ([]Job, []skippedLine, error) {
+	switch format {
+	case "text":
+		return readCommands(r, conf)
+	case "tsv":
+		return readTable(r, conf, '\t')
+	case "csv":
+		return readTable(r, conf, ',')
+	case "yaml":
+		jobs, err := readJobSpec(r, conf)
+		return jobs, nil, err
+	case "jsonl":
+		return readJSONLines(r, conf)
+	default:
+		return nil, nil, fmt.Errorf("unknown input format %q", format)
+	}
+}
+
+// sourceName labels an input in messages
+func sourceName(path string) string {
+	if path == "-" {
+		return "stdin"
+	}
+	return path
+}
+
+// sourceStem is the input file name without its extension, used by --prefix-source
+func sourceStem(path string) string {
+	base := filepath.Base(sourceName(path))
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// qualifyName prefixes a job name with its group, if any
+func qualifyName(group, name string) string {
+	if group == "" {
+		return name
+	}
+	return group + "_" + name
+}
+
+// readCommands parses a plain command list, applying any #slurmify directives
+func readCommands(r io.Reader, conf Config) ([]Job, []skippedLine, error) {
+	scanner := bufio.NewScanner(r)
+	var jobs []Job
+	var skipped []skippedLine
+	var errs []error
+
+	next := conf
+	var after []string
+
+	// Every job of a stage depends on all jobs of the previous non-empty stage
+	stage := ""
+	var prevStage, currStage []string
+
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+
+		if m := stagePattern.FindStringSubmatch(line); m != nil {
+			if len(currStage) > 0 {
+				prevStage, currStage = currStage, nil
+			}
+			stage = m[1]
+			continue
+		}
+
+		if spec, ok := cutDirective(line); ok {
+			if err := applyDirective(&next, spec); err != nil {
+				errs = append(errs, &lineError{Line: lineNo, Err: err})
+			}
+			continue
+		}
+		if deps, ok := strings.CutPrefix(line, afterPrefix+" "); ok {
+			after = append(after, strings.Fields(deps)...)
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			skipped = append(skipped, skippedLine{Line: lineNo, Reason: skipReason(line)})
+			continue
+		}
+
+		name, command := cutJobName(line)
+		after = append(after, prevStage...)
+		jobs = append(jobs, Job{Line: lineNo, Command: command, Raw: line, Name: name, After: after, Stage: stage, Conf: next})
+		currStage = append(currStage, strconv.Itoa(lineNo))
+
+		// Directives only apply to the command right after them
+		next = conf
+		after = nil
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("could not read input file: %w", err)
+	}
+	return jobs, skipped, errors.Join(errs...)
+}
+
+// cutJobName splits an explicit job name off a command line, if it has one
+func cutJobName(line string) (string, string) {
+	for _, pattern := range []*regexp.Regexp{namedPattern, bracketPattern} {
+		if m := pattern.FindStringSubmatch(line); m != nil {
+			return m[1], m[2]
+		}
+	}
+	return "", line
+}
+
+// cutDirective returns the settings part of a "#slurmify key=value ..." line
+func cutDirective(line string) (string, bool) {
+	rest, ok := strings.CutPrefix(line, directivePrefix)
+	if !ok || (rest != "" && rest[0] != ' ' && rest[0] != '\t') {
+		return "", false
+	}
+	return rest, true
+}
+
+// applyDirective applies space-separated key=value overrides to c
+func applyDirective(c *Config, spec string) error {
+	tokens, err := shlex.Split(spec)
+	if err != nil {
+		return fmt.Errorf("invalid directive: %w", err)
+	}
+	for _, tok := range tokens {
+		key, value, ok := strings.Cut(tok, "=")
+		if !ok {
+			return fmt.Errorf("invalid directive %q: expected key=value", tok)
+		}
+		if err := applySetting(c, key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readTable parses a TSV/CSV sheet whose header row names the columns.
+// "command" is required, "name" sets the job name, and any other column
+// is a setting applied to that row, e.g. cpus, mem, time or gres.
+func readTable(r io.Reader, conf Config, comma rune) ([]Job, []skippedLine, error) {
+	// The text is kept to tell the blank and comment lines the reader skips
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not read input file: %w", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comma = comma
+	reader.Comment = '#'
+	reader.LazyQuotes = true
+	reader.FieldsPerRecord = -1
+
+	var skipped []skippedLine
+	next := 1
+	skipTo := func(line int) {
+		for ; next < line && next <= len(lines); next++ {
+			skipped = append(skipped, skippedLine{Line: next, Reason: skipReason(lines[next-1])})
+		}
+	}
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		skipTo(len(lines) + 1)
+		return nil, skipped, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not read input header: %w", err)
+	}
+	headerLine, _ := reader.FieldPos(0)
+	skipTo(headerLine)
+	next = headerLine + 1
+
+	cmdCol := -1
+	for i, col := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(col))
+		if header[i] == "command" {
+			cmdCol = i
+		}
+	}
+	if cmdCol < 0 {
+		return nil, nil, fmt.Errorf("input header has no \"command\" column")
+	}
+
+	var jobs []Job
+	var errs []error
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not read input: %w", err)
+		}
+		lineNo, _ := reader.FieldPos(0)
+		lastLine, _ := reader.FieldPos(len(record) - 1)
+		skipTo(lineNo)
+		next = lastLine + 1
+
+		job := Job{Line: lineNo, Conf: conf}
+		for i, value := range record {
+			value = strings.TrimSpace(value)
+			if i >= len(header) || value == "" {
+				continue
+			}
+			switch header[i] {
+			case "command":
+				job.Command = value
+			case "name":
+				job.Name = value
+			default:
+				if err := applySetting(&job.Conf, header[i], value); err != nil {
+					errs = append(errs, &lineError{Line: lineNo, Err: err})
+				}
+			}
+		}
+		if job.Command == "" {
+			skipped = append(skipped, skippedLine{Line: lineNo, Reason: "no command"})
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	skipTo(len(lines) + 1)
+	return jobs, skipped, errors.Join(errs...)
+}
+
+// readJobSpec parses a YAML list of jobs, either at the top level or under a
+// "jobs" key. Each entry takes "command", "name", "after" and any setting key.
+func readJobSpec(r io.Reader, conf Config) ([]Job, error) {
+	var doc yaml.Node
+	if err := yaml.NewDecoder(r).Decode(&doc); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not parse input: %w", err)
+	}
+
+	list := doc.Content[0]
+	if list.Kind == yaml.MappingNode {
+		var wrapper struct {
+			Jobs yaml.Node `yaml:"jobs"`
+		}
+		if err := list.Decode(&wrapper); err != nil {
+			return nil, fmt.Errorf("could not parse input: %w", err)
+		}
+		if wrapper.Jobs.Kind == 0 {
+			return nil, fmt.Errorf("line %d: missing \"jobs\" key; expected a list of jobs or a mapping with a \"jobs\" list", list.Line)
+		}
+		list = &wrapper.Jobs
+	}
+	if list.Kind != yaml.SequenceNode {
+		return nil, fmt.Errorf("line %d: expected a list of jobs", list.Line)
+	}
+
+	jobs := make([]Job, 0, len(list.Content))
+	var errs []error
+	for _, node := range list.Content {
+		var entry map[string]any
+		if err := node.Decode(&entry); err != nil {
+			errs = append(errs, &lineError{Line: node.Line, Err: err})
+			continue
+		}
+
+		job, err := jobFromEntry(entry, conf)
+		if err != nil {
+			errs = append(errs, &lineError{Line: node.Line, Err: err})
+		}
+		// A job with a bad setting is kept so that its dependents still resolve
+		if job.Command != "" {
+			job.Line = node.Line
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, errors.Join(errs...)
+}
+
+// readJSONLines parses one JSON job object per line, using the same keys as
+// the YAML job spec. Blank lines are skipped; anything else must be valid.
+func readJSONLines(r io.Reader, conf Config) ([]Job, []skippedLine, error) {
+	scanner := bufio.NewScanner(r)
+	var jobs []Job
+	var skipped []skippedLine
+	var errs []error
+
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			skipped = append(skipped, skippedLine{Line: lineNo, Reason: skipReason(line)})
+			continue
+		}
+
+		var entry map[string]any
+		dec := json.NewDecoder(strings.NewReader(line))
+		dec.UseNumber()
+		if err := dec.Decode(&entry); err != nil {
+			errs = append(errs, &lineError{Line: lineNo, Err: fmt.Errorf("invalid JSON: %w", err)})
+			continue
+		}
+		if dec.More() {
+			errs = append(errs, &lineError{Line: lineNo, Err: errors.New("expected exactly one JSON object")})
+			continue
+		}
+
+		job, err := jobFromEntry(entry, conf)
+		if err != nil {
+			errs = append(errs, &lineError{Line: lineNo, Err: err})
+		}
+		if job.Command != "" {
+			job.Line = lineNo
+			jobs = append(jobs, job)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("could not read input file: %w", err)
+	}
+	return jobs, skipped, errors.Join(errs...)
+}
+
+// jobFromEntry builds a job from a decoded YAML or JSON object
+func jobFromEntry(entry map[string]any, conf Config) (Job, error) {
+	job := Job{Conf: conf}
+
+	// Sorted keys keep error messages stable
+	keys := make([]string, 0, len(entry))
+	for key := range entry {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := entry[key]
+		switch key {
+		case "command", "name":
+			s, ok := scalarString(value)
+			if !ok {
+				return job, fmt.Errorf("%q must be a string", key)
+			}
+			if key == "command" {
+				job.Command = strings.TrimSpace(s)
+			} else {
+				job.Name = s
+			}
+		case "after":
+			after, ok := scalarList(value)
+			if !ok {
+				return job, fmt.Errorf("\"after\" must be a job name or a list of job names")
+			}
+			job.After = after
+		default:
+			// null, maps and lists holding them are rejected, not stringified
+			values, ok := scalarList(value)
+			if !ok {
+				return job, fmt.Errorf("%q must be a value or a list of values", key)
+			}
+			if err := applySetting(&job.Conf, key, values...); err != nil {
+				return job, err
+			}
+		}
+	}
+
+	if job.Command == "" {
+		return job, fmt.Errorf("job has no command")
+	}
+	return job, nil
+}
+
+// scalarString renders strings, numbers and booleans; anything else is rejected
+func scalarString(v any) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case json.Number, int, float64, bool:
+		return fmt.Sprint(v), true
+	}
+	return "", false
+}
+
+// scalarList accepts either a single scalar or a list of them
+func scalarList(v any) ([]string, bool) {
+	list, isList := v.([]any)
+	if !isList {
+		s, ok := scalarString(v)
+		return []string{s}, ok
+	}
+	out := make([]string, len(list))
+	for i, item := range list {
+		s, ok := scalarString(item)
+		if !ok {
+			return nil, false
+		}
+		out[i] = s
+	}
+	return out, true
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Lines without a job are listed for the --json report, with the reason
+func TestReadersListSkippedLines(t *testing.T) {
+	_, skipped, err := readCommands(strings.NewReader("echo a\n\n# note\n#slurmify cpus=2\necho b\n"), defaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []skippedLine{{Line: 2, Reason: "blank line"}, {Line: 3, Reason: "comment"}}
+	if !slices.Equal(skipped, want) {
+		t.Errorf("text skipped %v, want %v", skipped, want)
+	}
+
+	sheet := "command\tname\n\n# note\necho a\ta\n\tb\n\"echo\nmulti\"\tc\n\n"
+	_, skipped, err = readTable(strings.NewReader(sheet), defaultConfig(), '\t')
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []skippedLine{{Line: 2, Reason: "blank line"}, {Line: 3, Reason: "comment"}, {Line: 5, Reason: "no command"}, {Line: 8, Reason: "blank line"}}
+	if !slices.Equal(skipped, want) {
+		t.Errorf("table skipped %v, want %v", skipped, want)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// --- LOG REPORT ---
+
+// Slurm logs are named <job name>_<job id>.err by the generated headers
+var errLogPattern = regexp.MustCompile(`^(.+)_(\d+)\.err$`)
+
+// States that are not failures
+var okStates = []string{"COMPLETED", "RUNNING", "PENDING", "REQUEUED"}
+
+// errLog is the latest error log of one job name
+type errLog struct {
+	Name  string
+	JobID string
+	Path  string
+}
+
+// runReport summarizes failed jobs from their error logs and sacct
+func runReport(args []string) error {
+	conf := defaultConfig()
+	fset := newCommandFlags("report", &conf)
+	var tail int
+	fset.IntVar(&tail, "lines", 5, "Number of trailing lines to show from each error log")
+	if err := parseArgs(fset, args, "slurmify report [-L <dir>] [--lines N]"); err != nil {
+		return err
+	}
+
+	logs, err := findErrLogs(conf.LogsDir)
+	if err != nil {
+		return err
+	}
+	if len(logs) == 0 {
+		return fmt.Errorf("no .err logs in %s/", conf.LogsDir)
+	}
+
+	ids := make([]string, len(logs))
+	for i, l := range logs {
+		ids[i] = l.JobID
+	}
+	// Without accounting, a non-empty error log is the only failure signal
+	states, err := sacctStates(ids)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[slurmify] Warning: %v; reporting every non-empty log\n", err)
+	}
+
+	failed := 0
+	for _, l := range logs {
+		lines, err := lastLines(l.Path, tail)
+		if err != nil {
+			return err
+		}
+		st, known := states[l.JobID]
+		switch {
+		case known && slices.Contains(okStates, st.State):
+			continue
+		case !known && states != nil:
+			st = JobState{State: "UNKNOWN", ExitCode: "-"}
+		case !known:
+			if len(lines) == 0 {
+				continue
+			}
+			st = JobState{State: "UNKNOWN", ExitCode: "-"}
+		}
+
+		failed++
+		fmt.Printf("[slurmify] %s (job %s): %s, exit %s\n", l.Name, l.JobID, st.State, st.ExitCode)
+		for _, line := range lines {
+			fmt.Printf("    %s\n", line)
+		}
+	}
+	fmt.Printf("[slurmify] %d of %d job(s) failed\n", failed, len(logs))
+	return nil
+}
+
+// findErrLogs returns the newest error log of every job name in dir
+func findErrLogs(dir string) ([]errLog, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read logs directory: %w", err)
+	}
+
+	latest := map[string]errLog{}
+	for _, e := range entries {
+		m := errLogPattern.FindStringSubmatch(e.Name())
+		if m == nil || e.IsDir() {
+			continue
+		}
+		prev, seen := latest[m[1]]
+		if seen && jobIDNumber(prev.JobID) > jobIDNumber(m[2]) {
+			continue
+		}
+		latest[m[1]] = errLog{Name: m[1], JobID: m[2], Path: filepath.Join(dir, e.Name())}
+	}
+
+	logs := make([]errLog, 0, len(latest))
+	for _, l := range latest {
+		logs = append(logs, l)
+	}
+	sort.Slice(logs, func(i, j int) bool { return jobIDNumber(logs[i].JobID) < jobIDNumber(logs[j].JobID) })
+	return logs, nil
+}
+
+// jobIDNumber orders job IDs numerically
+func jobIDNumber(id string) int64 {
+	n, _ := strconv.ParseInt(id, 10, 64)
+	return n
+}
+
+// lastLines returns up to n trailing non-blank lines of a file
+func lastLines(path string, n int) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" {
+			continue
+		}
+		lines = append(lines, line)
+		if len(lines) > n {
+			lines = lines[1:]
+		}
+	}
+	return lines, scanner.Err()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// --- MAKEFILE ---
+
+// makefileName is written to the output directory by --makefile
+const makefileName = "Makefile"
+
+// stampExt marks files holding the job ID of a submitted script; make only
+// resubmits scripts whose stamp is missing or older than the script
+const stampExt = ".jobid"
+
+// writeMakefile emits a Makefile with one stamp target per script, so the
+// batch can be driven with make and partially resubmitted
+func writeMakefile(conf Config, scripts []Script) (string, error) {
+	// Log paths in the scripts are relative to where slurmify ran; the path is
+	// quoted for the shell in the recipes, with $ escaped for make
+	workdir, err := submitWorkdir(conf, `"$(CURDIR)"`, func(path string) string {
+		return strings.ReplaceAll(quoteArg(path), "$", "$$")
+	})
+	if err != nil {
+		return "", err
+	}
+
+	stamps := make(map[int]string, len(scripts))
+	targets := make([]string, len(scripts))
+	for i, s := range scripts {
+		stamps[s.Index] = scriptStem(s.Path) + stampExt
+		targets[i] = stamps[s.Index]
+	}
+
+	var sb strings.Builder
+	sb.WriteString("# Generated by slurmify\n")
+	sb.WriteString("#   make submit      submit every script not yet submitted\n")
+	sb.WriteString("#   make status      show the sacct state of submitted jobs\n")
+	sb.WriteString("#   make clean-logs  remove the .out/.err logs and GPU traces of this batch\n")
+	sb.WriteString("# Delete a " + stampExt + " file (or touch its script) to resubmit that job.\n\n")
+	fmt.Fprintf(&sb, "WORKDIR := %s\n", workdir)
+	sb.WriteString("SBATCH := sbatch --parsable\n\n")
+	fmt.Fprintf(&sb, "JOBS := %s\n\n", strings.Join(targets, " "))
+	sb.WriteString(".PHONY: submit status clean-logs\n\n")
+	sb.WriteString("submit: $(JOBS)\n\n")
+	sb.WriteString("status:\n")
+	sb.WriteString("\t@ids=$$(cat $(wildcard $(JOBS)) 2>/dev/null | paste -sd, -); \\\n")
+	sb.WriteString("\tif [ -n \"$$ids\" ]; then sacct -X -j \"$$ids\" --format=JobID,JobName%40,State,Elapsed,ExitCode; \\\n")
+	sb.WriteString("\telse echo \"No jobs submitted yet\"; fi\n\n")
+
+	sb.WriteString("clean-logs:\n")
+	for _, s := range scripts {
+		base := quoteArg(filepath.Join(conf.LogsDir, s.Name))
+		fmt.Fprintf(&sb, "\tcd $(WORKDIR) && rm -f %s_*.out %s_*.err %s_*_gpu.csv\n", base, base, base)
+	}
+	sb.WriteString("\n")
+
+	for _, s := range scripts {
+		prereqs := []string{filepath.Base(s.Path)}
+		dep := ""
+		if len(s.After) > 0 {
+			ids := make([]string, len(s.After))
+			for j, idx := range s.After {
+				prereqs = append(prereqs, stamps[idx])
+				ids[j] = "$$(cat \"$(CURDIR)\"/" + stamps[idx] + ")"
+			}
+			dep = " --dependency=afterok:" + strings.Join(ids, ":")
+		}
+
+		fmt.Fprintf(&sb, "%s: %s\n", stamps[s.Index], strings.Join(prereqs, " "))
+		fmt.Fprintf(&sb, "\tid=$$(cd $(WORKDIR) && $(SBATCH)%s %s) && id=$${id%%%%;*} && \\\n", dep, quoteArg(s.Path))
+		fmt.Fprintf(&sb, "\tprintf '%%s\\t%%s\\n' \"$$id\" %s >> %s && echo \"$$id\" > $@ && \\\n", quoteArg(s.Path), jobsFileName)
+		fmt.Fprintf(&sb, "\techo \"Submitted %s as job $$id\"\n\n", s.Path)
+	}
+
+	path := filepath.Join(conf.OutputDir, makefileName)
+	if err := writeIfChanged(path, sb.String(), 0644); err != nil {
+		return "", fmt.Errorf("could not write %s: %w", path, err)
+	}
+	return path, nil
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- MANIFEST ---
+
+// manifestFileName maps each generated script back to its input line
+const manifestFileName = "manifest.tsv"
+
+// Manifest columns, one row per job; the hours are given once per script so
+// that the columns add up to the batch total
+var manifestHeader = []string{"source", "line", "name", "script", "command",
+	"partition", "account", "cpus", "mem", "time", "gres", "after",
+	"cpu_hours", "gpu_hours", "mem_gb_hours", "su"}
+
+// writeManifest records every job of the batch with its script, origin and resources
+func writeManifest(dir string, scripts []Script) (string, error) {
+	stems := make(map[int]string, len(scripts))
+	for _, s := range scripts {
+		stems[s.Index] = scriptStem(s.Path)
+	}
+
+	var sb strings.Builder
+	w := csv.NewWriter(&sb)
+	w.Comma = '\t'
+	w.Write(manifestHeader)
+	for _, s := range scripts {
+		c := s.Job.Conf
+		deps := make([]string, len(s.After))
+		for i, idx := range s.After {
+			deps[i] = stems[idx]
+		}
+		u := scriptUsage(s.Conf)
+		hours := []string{formatHours(u.CPUHours), formatHours(u.GPUHours), formatHours(u.MemGBHours), formatHours(u.SU)}
+		// A chunked script has one row per command
+		for k, job := range s.Jobs {
+			if k == 1 || u.Unlimited > 0 {
+				hours = []string{"", "", "", ""}
+			}
+			w.Write(append([]string{sourceName(job.Source), strconv.Itoa(job.Line), s.Name, s.Path, job.Command,
+				c.Partition, c.Account, strconv.Itoa(c.CPUs), memRequest(c), c.Time, c.Gres, strings.Join(deps, ",")}, hours...))
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, manifestFileName)
+	if err := writeIfChanged(path, sb.String(), 0644); err != nil {
+		return "", fmt.Errorf("could not write %s: %w", path, err)
+	}
+	return path, nil
+}
+
+// readManifest loads the scripts of a batch in submission order, with the
+// dependencies between them; without a manifest it returns no scripts
+func readManifest(dir string) ([]Script, error) {
+	path := filepath.Join(dir, manifestFileName)
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r := csv.NewReader(file)
+	r.Comma = '\t'
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	col := map[string]int{}
+	for i, name := range rows[0] {
+		col[name] = i
+	}
+	scriptCol, ok1 := col["script"]
+	afterCol, ok2 := col["after"]
+	if !ok1 || !ok2 {
+		return nil, fmt.Errorf("%s: no script and after columns", path)
+	}
+
+	var scripts []Script
+	byStem := map[string]int{}
+	for _, row := range rows[1:] {
+		stem := scriptStem(row[scriptCol])
+		// A chunked script has one row per command
+		if _, seen := byStem[stem]; seen {
+			continue
+		}
+		s := Script{Path: row[scriptCol], Index: len(scripts)}
+		for _, dep := range strings.Split(row[afterCol], ",") {
+			if dep == "" {
+				continue
+			}
+			idx, ok := byStem[dep]
+			if !ok {
+				return nil, fmt.Errorf("%s: %s depends on %s, which is not listed before it", path, s.Path, dep)
+			}
+			s.After = append(s.After, idx)
+		}
+		byStem[stem] = s.Index
+		scripts = append(scripts, s)
+	}
+	return scripts, nil
+}
+
+// scriptStem is the script file name without its extension, e.g. job_a_003
+func scriptStem(path string) string {
+	base := filepath.Base(path)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// --- METADATA ---
+
+// metaExt is appended to a script's stem for its metadata sidecar
+const metaExt = ".meta.json"
+
+// scriptMeta is the metadata sidecar of one script
+type scriptMeta struct {
+	Name        string        `json:"name"`
+	Script      string        `json:"script"`
+	Commands    []commandMeta `json:"commands"`
+	Resources   resourceMeta  `json:"resources"`
+	After       []string      `json:"after"`
+	Version     string        `json:"slurmify_version"`
+	GeneratedAt string        `json:"generated_at,omitempty"`
+}
+
+// commandMeta is a command of a script with the input line it came from
+type commandMeta struct {
+	Source  string `json:"source"`
+	Line    int    `json:"line"`
+	Command string `json:"command"`
+}
+
+// resourceMeta is what a script requests from Slurm
+type resourceMeta struct {
+	Partition string `json:"partition"`
+	Account   string `json:"account"`
+	Nodes     int    `json:"nodes"`
+	NTasks    int    `json:"ntasks"`
+	CPUs      int    `json:"cpus_per_task"`
+	Mem       string `json:"mem"`
+	Time      string `json:"time"`
+	Gres      string `json:"gres,omitempty"`
+	QOS       string `json:"qos,omitempty"`
+}
+
+// writeMetadata writes <name>.meta.json next to every script that was written
+// or is unchanged, for tools that work with the scripts of a batch
+func writeMetadata(scripts []Script, generated time.Time) error {
+	stems := make(map[int]string, len(scripts))
+	for _, s := range scripts {
+		stems[s.Index] = scriptStem(s.Path)
+	}
+	for _, s := range scripts {
+		// A kept script may not match the current input
+		if s.Keep && !s.Same {
+			continue
+		}
+		c := s.Conf
+		meta := scriptMeta{
+			Name:   s.Name,
+			Script: s.Path,
+			Resources: resourceMeta{
+				Partition: c.Partition, Account: c.Account, Nodes: c.Nodes, NTasks: totalTasks(c),
+				CPUs: c.CPUs, Mem: memRequest(c), Time: c.Time, Gres: c.Gres, QOS: c.QOS,
+			},
+			After:   make([]string, len(s.After)),
+			Version: version,
+		}
+		if !generated.IsZero() {
+			meta.GeneratedAt = generated.Format(time.RFC3339)
+		}
+		for _, job := range s.Jobs {
+			meta.Commands = append(meta.Commands, commandMeta{Source: sourceName(job.Source), Line: job.Line, Command: job.Command})
+		}
+		for i, idx := range s.After {
+			meta.After[i] = stems[idx]
+		}
+		data, err := json.MarshalIndent(meta, "", "  ")
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(filepath.Dir(s.Path), scriptStem(s.Path)+metaExt)
+		if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+			return fmt.Errorf("could not write %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// --- PROVENANCE ---
+
+// provenance records how a batch was generated, for the comment block at the
+// top of each script with --provenance
+type provenance struct {
+	generated  time.Time
+	invocation string
+	checksums  map[string]string // input path -> sha256, computed once per input
+}
+
+// newProvenance captures the command line slurmify was started with, unless
+// --deterministic leaves it out
+func newProvenance(conf Config) *provenance {
+	p := &provenance{generated: generatedAt(conf), checksums: map[string]string{}}
+	if !conf.Deterministic {
+		args := make([]string, len(os.Args)-1)
+		for i, arg := range os.Args[1:] {
+			args[i] = quoteArg(arg)
+		}
+		p.invocation = strings.TrimSpace("slurmify " + strings.Join(args, " "))
+	}
+	return p
+}
+
+// checksum is the sha256 of an input file; standard input has none
+func (p *provenance) checksum(path string) string {
+	if sum, ok := p.checksums[path]; ok {
+		return sum
+	}
+	sum := "unavailable"
+	if data, err := os.ReadFile(path); err == nil && path != "-" {
+		sum = fmt.Sprintf("sha256 %x", sha256.Sum256(data))
+	}
+	p.checksums[path] = sum
+	return sum
+}
+
+// block is the comment block for a script made from jobs of the given inputs
+func (p *provenance) block(jobs []Job) string {
+	var sb strings.Builder
+	if p.generated.IsZero() {
+		fmt.Fprintf(&sb, "# Generated by slurmify %s\n", version)
+	} else {
+		fmt.Fprintf(&sb, "# Generated by slurmify %s on %s\n", version, p.generated.Format(time.RFC3339))
+	}
+	if p.invocation != "" {
+		fmt.Fprintf(&sb, "# Invocation: %s\n", p.invocation)
+	}
+	seen := map[string]bool{}
+	for _, job := range jobs {
+		if !seen[job.Source] {
+			seen[job.Source] = true
+			fmt.Fprintf(&sb, "# Input: %s (%s)\n", sourceName(job.Source), p.checksum(job.Source))
+		}
+	}
+	return sb.String()
+}
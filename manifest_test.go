@@ -0,0 +1,38 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// submit reads the batch back from the manifest with its dependencies
+func TestManifestKeepsDependencies(t *testing.T) {
+	conf := defaultConfig()
+	conf.Account = "acct"
+	conf.OutputDir = t.TempDir()
+	conf.Chunk = 2
+	jobs, _, err := readCommands(strings.NewReader("echo a > a1\necho b > b2\n## stage: two\necho c > c4\n"), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scripts, err := renderScripts(jobs, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writeManifest(conf.OutputDir, scripts); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readManifest(conf.OutputDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(scripts) {
+		t.Fatalf("read %d scripts, want %d", len(got), len(scripts))
+	}
+	for i, s := range scripts {
+		if got[i].Path != s.Path || !slices.Equal(got[i].After, s.After) {
+			t.Errorf("script %d = %s after %v, want %s after %v", i, got[i].Path, got[i].After, s.Path, s.After)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/google/shlex"
+)
+
+// --- JOB NAMING ---
+
+// Extensions to strip, unless --trim-ext-replace
+var trimExts = map[string]bool{
+	".bam": true, ".sam": true, ".cram": true, ".bai": true, ".gz": true,
+	".bed": true, ".bw": true, ".txt": true, ".sorted": true, ".csi": true,
+	".tbi": true, ".fq": true, ".fastq": true, ".fa": true, ".fasta": true,
+	".fai": true, ".vcf": true, ".csv": true, ".tsv": true, ".log": true,
+	".out": true, ".err": true, ".json": true, ".yaml": true, ".yml": true,
+}
+
+// toolNameTemplate is the layout of --name-tool
+const toolNameTemplate = "{prefix}_{tool}_{base}"
+
+// deriveJobName builds the name of the idx-th job, from --name-template when
+// one is given; job numbers have width digits. With --no-derive the base is
+// always the number.
+func deriveJobName(cmd string, c Config, idx, width int) string {
+	base := ""
+	if !c.NoDerive {
+		base = deriveBase(cmd, c)
+	}
+	tmpl := c.NameTemplate
+	if tmpl == "" && c.NameTool {
+		tmpl = toolNameTemplate
+	}
+	if tmpl != "" {
+		return expandNameTemplate(tmpl, nameFields{
+			Prefix: c.JobPrefix, Index: idx, Width: width, Base: base, Tool: commandTool(cmd), Hash: commandHash(cmd),
+		})
+	}
+	if base == "" {
+		return c.JobPrefix + "_" + padIndex(idx, width)
+	}
+	return fmt.Sprintf("%s_%s", c.JobPrefix, base)
+}
+
+// indexWidth is the number of digits in job numbers: --pad, widened so that
+// every number of a batch of n has the same width and names sort in order
+func indexWidth(pad, n int) int {
+	return max(pad, len(strconv.Itoa(n)))
+}
+
+// padIndex zero-pads a job number to width digits
+func padIndex(idx, width int) string {
+	return fmt.Sprintf("%0*d", width, idx)
+}
+
+// Flags whose value names the output of a command, for job names; --name-flag
+// adds tool-specific ones
+var nameFlags = []string{"-o", "-O", "--output", "--out", "--prefix", "--output-prefix", "--outFileNamePrefix"}
+
+// isNameFlag reports whether flag names the output of a command
+func isNameFlag(flag string, c Config) bool {
+	return slices.Contains(nameFlags, flag) || slices.Contains(c.NameFlag.stringList, flag)
+}
+
+// deriveBase guesses a sample name from the command's output, or its last argument
+func deriveBase(cmd string, c Config) string {
+	parts, err := shlex.Split(cmd)
+	if err != nil {
+		parts = strings.Fields(cmd)
+	}
+	base := ""
+
+	// Check for a > redirect or an output flag, also as --output=path
+	for i, part := range parts {
+		if name, value, ok := strings.Cut(part, "="); ok && isNameFlag(name, c) {
+			base = pathBase(value)
+		} else if (part == ">" || part == ">>" || isNameFlag(part, c)) && i+1 < len(parts) {
+			base = pathBase(parts[i+1])
+		}
+		if base != "" {
+			break
+		}
+	}
+
+	// Fallback to last argument
+	if base == "" && len(parts) > 0 {
+		base = pathBase(parts[len(parts)-1])
+	}
+
+	if base != "" {
+		// Strip extensions loop
+		for {
+			ext := filepath.Ext(base)
+			if ext == "" || !trimmedExt(ext, c) {
+				break
+			}
+			base = strings.TrimSuffix(base, ext)
+		}
+		base = sanitizeName(base)
+	}
+	return base
+}
+
+// pathBase is the last element of a path without the separators an output
+// prefix such as out/s1_ ends in; directories like . give nothing
+func pathBase(path string) string {
+	base := strings.TrimRight(filepath.Base(path), "_-.")
+	if base == "" || base == string(filepath.Separator) {
+		return ""
+	}
+	return base
+}
+
+// trimmedExt reports whether ext is stripped from derived names
+func trimmedExt(ext string, c Config) bool {
+	if slices.Contains(c.TrimExt.stringList, ext) {
+		return true
+	}
+	return !c.TrimExtReplace && trimExts[ext]
+}
+
+// sanitizeName strips characters unsafe in filenames
+func sanitizeName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '*', '?', '/', '\\', ':', '"', '<', '>', '|', ' ':
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// Programs that run the command after them, and their options
+var commandWrappers = map[string]bool{"env": true, "time": true, "nohup": true, "exec": true}
+
+// Interpreters whose script says more about a command than they do
+var interpreters = map[string]bool{
+	"python": true, "python3": true, "Rscript": true, "perl": true, "bash": true, "sh": true, "julia": true,
+}
+
+// commandTool is the program a command runs, skipping leading variable
+// assignments and wrappers, e.g. bwa for "env OMP_NUM_THREADS=4 bwa mem ...";
+// for an interpreter it is the script, e.g. run_qc for "python3 run_qc.py"
+func commandTool(cmd string) string {
+	tool := ""
+	for _, part := range strings.Fields(cmd) {
+		name := filepath.Base(part)
+		switch {
+		case tool == "" && (commandWrappers[name] || strings.Contains(part, "=") || strings.HasPrefix(part, "-")):
+			continue
+		case tool == "" && interpreters[name]:
+			tool = name
+		case tool != "" && strings.HasPrefix(part, "-"):
+			// Interpreter options such as python3 -u
+			continue
+		case tool != "":
+			return sanitizeName(strings.TrimSuffix(name, filepath.Ext(name)))
+		default:
+			return sanitizeName(name)
+		}
+	}
+	return sanitizeName(tool)
+}
+
+// commandHash is a short hash of the command, stable across runs
+func commandHash(cmd string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(cmd)))[:8]
+}
+
+// maxJobName keeps job names, and the script, log and sidecar file names built
+// from them, well under the 255-byte file name limit
+const maxJobName = 200
+
+// slurmName makes a job name safe for sbatch and file names: only letters,
+// digits and _ - . + are kept, so % cannot start a log file pattern, and an
+// overlong name is cut and suffixed with a hash of the whole name
+func slurmName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case r == '_', r == '-', r == '.', r == '+':
+			return r
+		}
+		return -1
+	}, name)
+	if len(name) > maxJobName {
+		name = name[:maxJobName-9] + "_" + commandHash(name)
+	}
+	return name
+}
+
+// dedupeNames gives jobs that share a name distinct ones, by appending a hash
+// of their command, so the names stay put when input lines are reordered.
+// Identical commands are numbered on top. It returns the shared name of every
+// renamed job by index.
+func dedupeNames(names []string, jobs []Job) map[int]string {
+	count := map[string]int{}
+	for _, name := range names {
+		count[name]++
+	}
+	shared := map[int]string{}
+	taken := map[string]bool{}
+	for _, name := range names {
+		if count[name] == 1 {
+			taken[name] = true
+		}
+	}
+	for i, name := range names {
+		if count[name] == 1 {
+			continue
+		}
+		base := slurmName(name + "_" + commandHash(jobs[i].Command))
+		unique := base
+		for n := 2; taken[unique]; n++ {
+			unique = slurmName(fmt.Sprintf("%s_%d", base, n))
+		}
+		taken[unique] = true
+		names[i] = unique
+		shared[i] = name
+	}
+	return shared
+}
+
+// --- NAME TEMPLATES ---
+
+// nameFieldPattern matches a --name-template field such as {base} or {index:03d}
+var nameFieldPattern = regexp.MustCompile(`\{(\w+)(?::0?(\d+)d)?\}`)
+
+// Fields of --name-template
+var nameFieldNames = []string{"prefix", "index", "base", "tool", "hash"}
+
+// nameFields are the values a --name-template can use
+type nameFields struct {
+	Prefix string
+	Index  int
+	Width  int // digits of {index} without an explicit width
+	Base   string
+	Tool   string
+	Hash   string
+}
+
+// validateNameTemplate accepts templates using only known fields, with a
+// width only on {index}
+func validateNameTemplate(s string) error {
+	for _, m := range nameFieldPattern.FindAllStringSubmatch(s, -1) {
+		if !slices.Contains(nameFieldNames, m[1]) {
+			return fmt.Errorf("unknown name template field {%s} (use %s)", m[1], strings.Join(nameFieldNames, ", "))
+		}
+		if m[2] != "" && m[1] != "index" {
+			return fmt.Errorf("only {index} takes a width, not {%s}", m[1])
+		}
+	}
+	if rest := nameFieldPattern.ReplaceAllString(s, ""); strings.ContainsAny(rest, "{}") {
+		return fmt.Errorf("invalid name template %q (fields look like {base} or {index:03d})", s)
+	}
+	return nil
+}
+
+// expandNameTemplate fills in a --name-template. A missing base falls back to
+// the index, and separators left around empty fields are dropped.
+func expandNameTemplate(tmpl string, f nameFields) string {
+	name := nameFieldPattern.ReplaceAllStringFunc(tmpl, func(field string) string {
+		m := nameFieldPattern.FindStringSubmatch(field)
+		switch m[1] {
+		case "prefix":
+			return f.Prefix
+		case "index":
+			if m[2] == "" {
+				return padIndex(f.Index, f.Width)
+			}
+			return fmt.Sprintf("%0"+m[2]+"d", f.Index)
+		case "base":
+			if f.Base == "" {
+				return padIndex(f.Index, f.Width)
+			}
+			return f.Base
+		case "tool":
+			return f.Tool
+		case "hash":
+			return f.Hash
+		}
+		return field
+	})
+	name = repeatedSeparators.ReplaceAllString(sanitizeName(name), "$1")
+	return strings.Trim(name, "_-.")
+}
+
+// repeatedSeparators matches runs of a separator left by empty fields
+var repeatedSeparators = regexp.MustCompile(`([_\-.])[_\-.]+`)
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// --- NOTIFICATIONS ---
+
+// webhookURL writes a webhook for the script: $VAR or a bare variable name is
+// read from the job's environment, so secrets stay out of the script
+func webhookURL(v string) string {
+	if name, ok := strings.CutPrefix(v, "$"); ok {
+		v = strings.Trim(name, "{}")
+	}
+	if envNamePattern.MatchString(v) {
+		return `"${` + v + `:-}"`
+	}
+	return quoteArg(v)
+}
+
+// postJSON is the shell line that posts a JSON body to a webhook; a failing
+// post is reported but never fails the job
+func postJSON(url, body string) string {
+	return fmt.Sprintf(`curl -fsS -m 10 -X POST -H 'Content-Type: application/json' -d %s %s >/dev/null || echo "[$(date)] Could not send the notification"`,
+		body, url)
+}
+
+// notifyHooks are the exit hooks that report the end of a job to webhooks and
+// to a Prometheus Pushgateway
+func notifyHooks(c Config) []string {
+	hooks := pushHooks(c)
+	// A --sentinel job notifies once for the whole batch instead
+	if c.Sentinel || (c.NotifySlack == "" && c.NotifyURL == "") {
+		return hooks
+	}
+	// The lowercase form is spelled out, as ${state,,} is bash only
+	hooks = append(hooks, `if [[ "$status" -eq 0 ]]; then state=COMPLETED result=completed; else state=FAILED result=failed; fi`)
+	if c.NotifySlack != "" {
+		// Slack and Mattermost incoming webhooks both take a text field
+		hooks = append(hooks, postJSON(webhookURL(c.NotifySlack),
+			`"{\"text\": \"Job $SLURM_JOB_NAME ($SLURM_JOB_ID) $result with exit code $status after ${SECONDS}s\"}"`))
+	}
+	if c.NotifyURL != "" {
+		hooks = append(hooks, postJSON(webhookURL(c.NotifyURL),
+			`"{\"job_name\": \"$SLURM_JOB_NAME\", \"job_id\": \"$SLURM_JOB_ID\", \"state\": \"$state\", \"exit_code\": $status, \"runtime_seconds\": $SECONDS}"`))
+	}
+	return hooks
+}
+
+// pushHooks push the duration, exit code and peak memory of a job to the
+// --pushgateway, grouped by job name and batch. The batch is the name of the
+// output directory; peak memory comes from sstat and is left out without it.
+func pushHooks(c Config) []string {
+	if c.Pushgateway == "" {
+		return nil
+	}
+	addr := strings.TrimSuffix(c.Pushgateway, "/")
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	batch := url.PathEscape(filepath.Base(absPath(c.OutputDir)))
+	target := fmt.Sprintf(`"%s/metrics/job/$SLURM_JOB_NAME/batch/%s"`, addr, batch)
+	return []string{
+		`rss=$(sstat -n -P -j "$SLURM_JOB_ID.batch" -o MaxRSS 2>/dev/null | head -n 1 | numfmt --from=iec 2>/dev/null || true)`,
+		`{ echo "slurmify_job_duration_seconds $SECONDS"; echo "slurmify_job_exit_code $status"; if [[ -n "$rss" ]]; then echo "slurmify_job_max_rss_bytes $rss"; fi; } | ` +
+			`curl -fsS -m 10 --data-binary @- ` + target + ` >/dev/null || echo "[$(date)] Could not push metrics"`,
+	}
+}
+
+// --- SENTINEL ---
+
+// sentinelName is the script of the job that reports the end of a batch
+const sentinelName = "sentinel.sh"
+
+// writeSentinel writes the sentinel script: a small job that runs after every
+// job of the batch, whatever their outcome, and sends a single summary of their
+// states by email and to the webhooks. It takes the job IDs as its argument.
+func writeSentinel(conf Config) (string, error) {
+	name := conf.JobPrefix + "_sentinel"
+	var sb strings.Builder
+	sb.WriteString(shebang(conf))
+	fmt.Fprintf(&sb, "#SBATCH --job-name=%s\n", name)
+	fmt.Fprintf(&sb, "#SBATCH --account=%s\n", conf.Account)
+	if conf.Partition != partitionAuto {
+		fmt.Fprintf(&sb, "#SBATCH --partition=%s\n", conf.Partition)
+	}
+	sb.WriteString("#SBATCH --ntasks=1\n")
+	sb.WriteString("#SBATCH --cpus-per-task=1\n")
+	sb.WriteString("#SBATCH --mem=256M\n")
+	sb.WriteString("#SBATCH --time=00:10:00\n")
+	fmt.Fprintf(&sb, "#SBATCH --output=%s/%s_%%j.out\n", sbatchPath(conf.LogsDir, conf), name)
+	fmt.Fprintf(&sb, "#SBATCH --error=%s/%s_%%j.err\n\n", sbatchPath(conf.LogsDir, conf), name)
+	sb.WriteString("# Usage: sbatch --dependency=afterany:<ids> " + sentinelName + " <comma-separated ids>\n")
+	sb.WriteString(strictMode(conf))
+	sb.WriteString("ids=$1\n")
+	sb.WriteString(`summary=$(sacct -X -n -P -j "$ids" -o State | awk '{print $1}' | sort | uniq -c | awk '{printf "%s%d %s", sep, $1, $2; sep = ", "}')` + "\n")
+	sb.WriteString("total=$(tr ',' '\\n' <<< \"$ids\" | wc -l)\n")
+	batch := filepath.Base(absPath(conf.OutputDir))
+	fmt.Fprintf(&sb, "message=\"Batch %s finished: $total job(s), $summary\"\n", batch)
+	sb.WriteString("echo \"[$(date)] $message\"\n")
+	if conf.Email != "" {
+		fmt.Fprintf(&sb, "if command -v mail >/dev/null; then mail -s \"$message\" %s <<< \"$message\" || true; fi\n", quoteArg(conf.Email))
+	}
+	if conf.NotifySlack != "" {
+		sb.WriteString(postJSON(webhookURL(conf.NotifySlack), `"{\"text\": \"$message\"}"`) + "\n")
+	}
+	if conf.NotifyURL != "" {
+		sb.WriteString(postJSON(webhookURL(conf.NotifyURL), `"{\"batch\": \"`+batch+`\", \"job_ids\": \"$ids\", \"jobs\": $total, \"states\": \"$summary\"}"`) + "\n")
+	}
+
+	path := filepath.Join(conf.OutputDir, sentinelName)
+	if err := writeIfChanged(path, sb.String(), 0755); err != nil {
+		return "", fmt.Errorf("could not write %s: %w", path, err)
+	}
+	return path, nil
+}
+
+// submitSentinel submits the sentinel after every job that was submitted
+func submitSentinel(path string, subs []Submission) (string, error) {
+	var ids []string
+	for _, s := range subs {
+		if s.Err == nil {
+			ids = append(ids, s.JobID)
+		}
+	}
+	if len(ids) == 0 {
+		return "", fmt.Errorf("no job of the batch was submitted")
+	}
+	return runSbatch("--dependency=afterany:"+strings.Join(ids, ":"), path, strings.Join(ids, ","))
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/shlex"
+)
+
+// --- TOOL PRESETS ---
+
+// builtinPresets are typical requests of common bioinformatics tools, keyed by
+// the program name or the program and its subcommand
+var builtinPresets = map[string]settings{
+	"bwa mem":        {"cpus": {"8"}, "mem": {"16G"}, "time": {"12:00:00"}},
+	"bwa-mem2 mem":   {"cpus": {"8"}, "mem": {"32G"}, "time": {"08:00:00"}},
+	"bowtie2":        {"cpus": {"8"}, "mem": {"8G"}, "time": {"12:00:00"}},
+	"minimap2":       {"cpus": {"8"}, "mem": {"16G"}, "time": {"08:00:00"}},
+	"hisat2":         {"cpus": {"8"}, "mem": {"16G"}, "time": {"08:00:00"}},
+	"STAR":           {"cpus": {"8"}, "mem": {"40G"}, "time": {"08:00:00"}},
+	"salmon":         {"cpus": {"8"}, "mem": {"16G"}, "time": {"04:00:00"}},
+	"kallisto":       {"cpus": {"4"}, "mem": {"8G"}, "time": {"02:00:00"}},
+	"samtools sort":  {"cpus": {"4"}, "mem": {"8G"}, "time": {"04:00:00"}},
+	"samtools index": {"cpus": {"1"}, "mem": {"2G"}, "time": {"01:00:00"}},
+	"gatk":           {"cpus": {"4"}, "mem": {"16G"}, "time": {"12:00:00"}},
+	"bcftools call":  {"cpus": {"2"}, "mem": {"4G"}, "time": {"04:00:00"}},
+	"featureCounts":  {"cpus": {"4"}, "mem": {"8G"}, "time": {"02:00:00"}},
+	"fastp":          {"cpus": {"4"}, "mem": {"8G"}, "time": {"02:00:00"}},
+	"fastqc":         {"cpus": {"2"}, "mem": {"4G"}, "time": {"02:00:00"}},
+}
+
+// loadPresets merges the tools sections of the config files over the built-in presets
+func loadPresets() (map[string]settings, error) {
+	fc, err := loadConfigFiles()
+	if err != nil {
+		return nil, err
+	}
+	presets := make(map[string]settings, len(builtinPresets)+len(fc.Tools))
+	for tool, values := range builtinPresets {
+		presets[tool] = settings{}
+		mergeSettings(presets[tool], values)
+	}
+	for tool, values := range fc.Tools {
+		if presets[tool] == nil {
+			presets[tool] = settings{}
+		}
+		mergeSettings(presets[tool], values)
+	}
+	return presets, nil
+}
+
+// matchPreset finds the preset for a command, preferring program and subcommand
+// over the program alone; the program may be given as a path
+func matchPreset(cmd string, presets map[string]settings) (settings, bool) {
+	fields := strings.Fields(cmd)
+	if len(fields) == 0 {
+		return nil, false
+	}
+	program := filepath.Base(fields[0])
+	if len(fields) > 1 {
+		if p, ok := presets[program+" "+fields[1]]; ok {
+			return p, true
+		}
+	}
+	p, ok := presets[program]
+	return p, ok
+}
+
+// applyPreset fills in a job's resources from its tool preset, leaving
+// settings given on the command line or for the job itself alone
+func applyPreset(job *Job, presets map[string]settings) error {
+	if !job.Conf.Presets {
+		return nil
+	}
+	preset, ok := matchPreset(job.Command, presets)
+	if !ok {
+		return nil
+	}
+
+	names := make([]string, 0, len(preset))
+	for name := range preset {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		// Per-CPU memory is kept over a preset's --mem
+		if job.Conf.set[name] || name == "mem" && job.Conf.MemPerCPU != "" {
+			continue
+		}
+		if err := applySetting(&job.Conf, name, preset[name]...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// --- INPUT SCALING ---
+
+// Redirections whose target is written rather than read
+var outputRedirects = map[string]bool{">": true, ">>": true, "1>": true, "2>": true, "&>": true}
+
+// inputFiles lists the existing files a command names, including --flag=file
+// and KEY=file arguments. The program, redirection targets and the values of
+// output flags are skipped, so outputs left by an earlier run are not inputs.
+func inputFiles(cmd string, c Config) []string {
+	tokens, err := shlex.Split(cmd)
+	if err != nil {
+		return nil
+	}
+	var files []string
+	seen := map[string]bool{}
+	for i := 1; i < len(tokens); i++ {
+		token := tokens[i]
+		if outputRedirects[token] || isNameFlag(token, c) {
+			i++
+			continue
+		}
+		// A redirection written together with its target, e.g. 2>log
+		if strings.HasPrefix(strings.TrimLeft(token, "0123456789&"), ">") {
+			continue
+		}
+		if name, value, ok := strings.Cut(token, "="); ok {
+			if isNameFlag(name, c) {
+				continue
+			}
+			token = value
+		}
+		if seen[token] {
+			continue
+		}
+		seen[token] = true
+		if info, err := os.Stat(token); err == nil && info.Mode().IsRegular() {
+			files = append(files, token)
+		}
+	}
+	return files
+}
+
+// inputBytes sums the sizes of the input files of a command
+func inputBytes(cmd string, c Config) int64 {
+	var total int64
+	for _, path := range inputFiles(cmd, c) {
+		if info, err := os.Stat(path); err == nil {
+			total += info.Size()
+		}
+	}
+	return total
+}
+
+// applyInputScaling adds --mem-per-input times and --time-per-gb per GiB of the
+// job's input files to its memory and time, rounded up to whole GiB and minutes
+func applyInputScaling(c *Config, cmd string) error {
+	if c.MemPerInput <= 0 && c.TimePerGB == "" {
+		return nil
+	}
+	size := inputBytes(cmd, *c)
+	if size == 0 {
+		return nil
+	}
+	gib := float64(size) / (1 << 30)
+
+	if c.MemPerInput > 0 && c.MemPerCPU != "" {
+		// The memory is requested per CPU, so each CPU gets its share, in whole MiB
+		base, err := parseMem(c.MemPerCPU)
+		if err != nil {
+			return fmt.Errorf("invalid value for mem-per-cpu: %w", err)
+		}
+		c.MemPerCPU = formatMem(base + int64(math.Ceil(gib*c.MemPerInput*1024/float64(max(c.CPUs, 1))))<<10)
+	} else if c.MemPerInput > 0 {
+		base, err := parseMem(c.Mem)
+		if err != nil {
+			return fmt.Errorf("invalid value for mem: %w", err)
+		}
+		c.Mem = formatMem(base + int64(math.Ceil(gib*c.MemPerInput))<<20)
+	}
+	if c.TimePerGB != "" {
+		per, ok, err := parseTime(c.TimePerGB)
+		if err != nil || !ok {
+			return fmt.Errorf("invalid value for time-per-gb: %q", c.TimePerGB)
+		}
+		base, ok, err := parseTime(c.Time)
+		if err != nil {
+			return fmt.Errorf("invalid value for time: %w", err)
+		}
+		if ok {
+			minutes := math.Ceil((base + time.Duration(gib*float64(per))).Minutes())
+			c.Time = formatTime(time.Duration(minutes) * time.Minute)
+		}
+	}
+	return nil
+}
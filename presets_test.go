@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"slices"
+	"testing"
+)
+
+// Outputs left by an earlier run exist too, but only the read files are inputs
+func TestInputFilesSkipOutputs(t *testing.T) {
+	t.Chdir(t.TempDir())
+	for _, name := range []string{"in.bam", "out.bam", "out2.bam", "log", "err"} {
+		if err := os.WriteFile(name, []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmd := "samtools sort -o out.bam --output=out2.bam in.bam > log 2>err"
+	if got, want := inputFiles(cmd, defaultConfig()), []string{"in.bam"}; !slices.Equal(got, want) {
+		t.Errorf("inputFiles = %q, want %q", got, want)
+	}
+}
+
+// With --mem-per-cpu the input memory is spread over the CPUs instead of added to --mem
+func TestInputScalingPerCPU(t *testing.T) {
+	t.Chdir(t.TempDir())
+	// A sparse 1 GiB input
+	if err := os.WriteFile("in.bam", nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate("in.bam", 1<<30); err != nil {
+		t.Fatal(err)
+	}
+	c := defaultConfig()
+	c.CPUs = 4
+	c.MemPerCPU = "1G"
+	c.MemPerInput = 2
+	if err := applyInputScaling(&c, "samtools index in.bam"); err != nil {
+		t.Fatal(err)
+	}
+	if c.MemPerCPU != "1536M" || c.Mem != defaultConfig().Mem {
+		t.Errorf("mem-per-cpu %s, mem %s; want 1536M and the default mem", c.MemPerCPU, c.Mem)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- RESOURCES ---
+
+// Memory units in KiB; a bare number is in megabytes, like sbatch
+var memUnits = []struct {
+	suffix string
+	kib    int64
+}{{"T", 1 << 30}, {"G", 1 << 20}, {"M", 1 << 10}, {"K", 1}}
+
+// parseMem converts a Slurm memory size like 4G to KiB
+func parseMem(s string) (int64, error) {
+	if err := validateMem(s); err != nil {
+		return 0, err
+	}
+	num, unit := s, "M"
+	if last := s[len(s)-1:]; last < "0" || last > "9" {
+		num, unit = s[:len(s)-1], strings.ToUpper(last)
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	for _, u := range memUnits {
+		if u.suffix == unit {
+			return n * u.kib, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid memory %q", s)
+}
+
+// formatMem writes KiB in the largest unit that keeps the value whole
+func formatMem(kib int64) string {
+	for _, u := range memUnits {
+		if kib%u.kib == 0 {
+			return fmt.Sprintf("%d%s", kib/u.kib, u.suffix)
+		}
+	}
+	return fmt.Sprintf("%dK", kib)
+}
+
+// parseTime converts a Slurm walltime to a duration; unlimited is reported as ok=false
+func parseTime(s string) (d time.Duration, ok bool, err error) {
+	if !timePattern.MatchString(s) {
+		return 0, false, fmt.Errorf("invalid time %q", s)
+	}
+	if s == "INFINITE" || s == "UNLIMITED" {
+		return 0, false, nil
+	}
+
+	days, hasDays := 0, false
+	if d, rest, found := strings.Cut(s, "-"); found {
+		days, _ = strconv.Atoi(d)
+		s, hasDays = rest, true
+	}
+	var parts []int
+	for _, p := range strings.Split(s, ":") {
+		n, _ := strconv.Atoi(p)
+		parts = append(parts, n)
+	}
+
+	var h, m, sec int
+	switch {
+	case hasDays:
+		// D-H, D-H:M, D-H:M:S
+		h = parts[0]
+		if len(parts) > 1 {
+			m = parts[1]
+		}
+		if len(parts) > 2 {
+			sec = parts[2]
+		}
+	case len(parts) == 1:
+		m = parts[0]
+	case len(parts) == 2:
+		m, sec = parts[0], parts[1]
+	default:
+		h, m, sec = parts[0], parts[1], parts[2]
+	}
+	total := ((days*24+h)*60+m)*60 + sec
+	return time.Duration(total) * time.Second, true, nil
+}
+
+// formatTime writes a duration as HH:MM:SS, or D-HH:MM:SS from one day on
+func formatTime(d time.Duration) string {
+	total := int64(math.Ceil(d.Seconds()))
+	days, rest := total/86400, total%86400
+	clock := fmt.Sprintf("%02d:%02d:%02d", rest/3600, rest%3600/60, rest%60)
+	if days > 0 {
+		return fmt.Sprintf("%d-%s", days, clock)
+	}
+	return clock
+}
+
+// scaleMem multiplies a memory size, rounding up
+func scaleMem(s string, factor float64) (string, error) {
+	kib, err := parseMem(s)
+	if err != nil {
+		return "", err
+	}
+	return formatMem(int64(math.Ceil(float64(kib) * factor))), nil
+}
+
+// scaleTime multiplies a walltime, rounding up; unlimited stays unlimited
+func scaleTime(s string, factor float64) (string, error) {
+	d, ok, err := parseTime(s)
+	if err != nil || !ok {
+		return s, err
+	}
+	return formatTime(time.Duration(float64(d) * factor)), nil
+}
+
+// tasksPerNode is the largest number of a job's tasks that share a node
+func tasksPerNode(c Config) int {
+	return (totalTasks(c) + c.Nodes - 1) / max(c.Nodes, 1)
+}
+
+// nodeMem is the memory a job requests per node in KiB, from --mem or --mem-per-cpu
+func nodeMem(c Config) (int64, error) {
+	if c.MemPerCPU == "" {
+		return parseMem(c.Mem)
+	}
+	kib, err := parseMem(c.MemPerCPU)
+	return kib * int64(c.CPUs*tasksPerNode(c)), err
+}
+
+// memRequest shows a job's memory request, with per-CPU requests marked
+func memRequest(c Config) string {
+	if c.MemPerCPU != "" {
+		return c.MemPerCPU + "/cpu"
+	}
+	return c.Mem
+}
+
+// resolveMem makes --mem and --mem-per-cpu exclusive on the command line, where
+// giving both is an error. An explicit --mem replaces --mem-per-cpu from a config
+// file or the environment; per job, the setting given last wins (see applySetting).
+func resolveMem(c *Config) error {
+	switch {
+	case c.set["mem"] && c.set["mem-per-cpu"]:
+		return fmt.Errorf("--mem and --mem-per-cpu are mutually exclusive")
+	case c.set["mem"]:
+		c.MemPerCPU = ""
+	}
+	return nil
+}
+
+// resolveGPUs turns --gpus into the GRES it stands for, so that everything
+// reading GPU requests sees one form. --gpus and --gres exclude each other on
+// the command line; an explicit one replaces the other from config files.
+func resolveGPUs(c *Config) error {
+	switch {
+	case c.set["gres"] && c.set["gpus"]:
+		return fmt.Errorf("--gres and --gpus are mutually exclusive")
+	case c.set["gres"]:
+		c.Gpus = ""
+	case c.Gpus != "":
+		c.Gres = "gpu:" + c.Gpus
+	}
+	return nil
+}
+
+// --- USAGE ---
+
+// usage is the compute a batch requests, in hours of each resource
+type usage struct {
+	CPUHours   float64 `json:"cpu_hours"`
+	GPUHours   float64 `json:"gpu_hours"`
+	MemGBHours float64 `json:"mem_gb_hours"`
+	SU         float64 `json:"su,omitempty"`        // estimated cost under the --su-per-* rates
+	Unlimited  int     `json:"unlimited,omitempty"` // scripts without a time limit, not counted
+	charged    bool
+}
+
+// scriptUsage is the usage of one script over its full time limit; memory
+// and GPUs are per node
+func scriptUsage(c Config) usage {
+	d, ok, err := parseTime(c.Time)
+	if err != nil || !ok {
+		return usage{Unlimited: 1}
+	}
+	hours := d.Hours()
+	u := usage{
+		CPUHours: float64(c.CPUs*totalTasks(c)) * hours,
+		GPUHours: float64(gpuCount(c.Gres)*c.Nodes) * hours,
+	}
+	if kib, err := nodeMem(c); err == nil {
+		u.MemGBHours = float64(kib) / (1 << 20) * float64(c.Nodes) * hours
+	}
+	u.charged = charged(c)
+	u.SU = u.CPUHours*c.SUPerCPUHour + u.GPUHours*c.SUPerGPUHour + u.MemGBHours*c.SUPerGBHour
+	return u
+}
+
+// charged reports whether a charging model is configured
+func charged(c Config) bool {
+	return c.SUPerCPUHour > 0 || c.SUPerGPUHour > 0 || c.SUPerGBHour > 0
+}
+
+// batchUsage adds up the usage of every script
+func batchUsage(scripts []Script) usage {
+	var total usage
+	for _, s := range scripts {
+		u := scriptUsage(s.Conf)
+		total.CPUHours += u.CPUHours
+		total.GPUHours += u.GPUHours
+		total.MemGBHours += u.MemGBHours
+		total.Unlimited += u.Unlimited
+		total.SU += u.SU
+		total.charged = total.charged || u.charged
+	}
+	return total
+}
+
+// String is the summary line printed after generation
+func (u usage) String() string {
+	line := fmt.Sprintf("[slurmify] Requested: %s CPU-hours, %s GPU-hours, %s GB-hours of memory\n",
+		formatHours(u.CPUHours), formatHours(u.GPUHours), formatHours(u.MemGBHours))
+	if u.charged {
+		line += fmt.Sprintf("[slurmify] Estimated cost: %s SU\n", formatHours(u.SU))
+	}
+	if u.Unlimited > 0 {
+		line += fmt.Sprintf("[slurmify] %d script(s) without a time limit are not counted\n", u.Unlimited)
+	}
+	return line
+}
+
+// formatHours rounds hours and service units to two decimals
+func formatHours(h float64) string {
+	return strconv.FormatFloat(h, 'f', 2, 64)
+}
+
+// gpuCount reads the number of GPUs per node from a gres like gpu:2 or gpu:a100:2
+func gpuCount(gres string) int {
+	total := 0
+	for _, res := range strings.Split(gres, ",") {
+		parts := strings.Split(res, ":")
+		if parts[0] != "gpu" {
+			continue
+		}
+		n, err := strconv.Atoi(parts[len(parts)-1])
+		if err != nil || len(parts) == 1 {
+			n = 1
+		}
+		total += n
+	}
+	return total
+}
@@ -0,0 +1,1071 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"github.com/google/shlex"
+)
+
+// --- SCRIPT RENDERING ---
+
+// Pre-compile regex
+var safeArgPattern = regexp.MustCompile(`^[a-zA-Z0-9_\-\./@=:,+]+$`)
+
+// ScriptData holds the rendered sections of a script; it is also the data
+// passed to --template files
+type ScriptData struct {
+	JobName    string
+	Command    string // pretty-printed with line continuations
+	RawCommand string // the command exactly as given in the input
+	Header     string // #SBATCH directives
+	Setup      string // strict mode, banner and environment setup
+	Provenance string // how the script was generated, with --provenance
+	Shebang    string // interpreter line chosen by --shell
+	Line       int
+	Config     Config
+}
+
+// newScriptData renders every section of the script for one job
+func newScriptData(job Job, jobName string) ScriptData {
+	c := job.Conf
+	if c.Checkpointable {
+		c = checkpointConfig(c)
+	}
+	var header, setup, command strings.Builder
+
+	// 1. Header
+	writeSbatchHeader(&header, jobName, c)
+
+	// 2. Body Setup
+	setup.WriteString(strictMode(c))
+	setup.WriteString("echo \"[$(date)] Job $SLURM_JOB_ID running on $(hostname)\"\n")
+	if c.Checkpointable {
+		setup.WriteString("echo \"[$(date)] Restart ${SLURM_RESTART_COUNT:-0}\"\n")
+	}
+	if c.Gres != "" {
+		setup.WriteString("echo \"[$(date)] CUDA_VISIBLE_DEVICES=${CUDA_VISIBLE_DEVICES:-unset}\"\n")
+	}
+	if c.GPUPreflight && gpuCount(c.Gres) > 0 {
+		writeGPUPreflight(&setup)
+	}
+	if strings.EqualFold(c.Export, "NONE") {
+		// sbatch passes NONE on to srun, which would drop what the script sets up
+		setup.WriteString("export SLURM_EXPORT_ENV=ALL\n")
+	}
+	if c.InjectThreads != "" {
+		setup.WriteString("export OMP_NUM_THREADS=${SLURM_CPUS_PER_TASK:-1}\n")
+	}
+	if c.Tmp != "" {
+		// Temporary files go to node-local disk; Slurm cleans SLURM_TMPDIR, the trap the fallback
+		setup.WriteString("export TMPDIR=\"${SLURM_TMPDIR:-/tmp/slurm_$SLURM_JOB_ID}\"\n")
+		setup.WriteString("mkdir -p \"$TMPDIR\"\n")
+	}
+	if c.Chdir != "" && c.CreateChdir {
+		// Slurm starts in /tmp when the directory is missing
+		dir := scriptPath(c.Chdir, c)
+		fmt.Fprintf(&setup, "mkdir -p %s\n", dir)
+		fmt.Fprintf(&setup, "cd %s\n", dir)
+	}
+	if c.JobWorkdir != "" {
+		writeJobWorkdir(&setup, jobName, job.Command, c)
+	}
+	if c.StageScratch {
+		writeStageIn(&setup, job.Command, c)
+	}
+	setup.WriteString("\n")
+	var hooks []string
+	if c.StageScratch {
+		extra := c.StageOut
+		if c.Checksums {
+			extra = append(checksumFiles(job.Command), extra...)
+		}
+		hooks = stageOutHooks(job.Command, extra)
+	}
+	writeExitTrap(&setup, append(hooks, exitHooks(c)...))
+	writeSignalTrap(&setup, c)
+	if gpuMonitored(c) {
+		writeGPUMonitor(&setup, jobName, c)
+	}
+
+	if c.ModulePurge {
+		setup.WriteString("module purge\n")
+	}
+	if c.ModuleCollection != "" {
+		fmt.Fprintf(&setup, "module restore %s\n", c.ModuleCollection)
+	}
+	for _, name := range c.Module.stringList {
+		fmt.Fprintf(&setup, "module load %s\n", name)
+	}
+	if c.ModulePurge || c.ModuleCollection != "" || len(c.Module.stringList) > 0 {
+		setup.WriteString("\n")
+	}
+	writeContainerPull(&setup, c)
+	writeActivation(&setup, c)
+	if len(c.Env.stringList) > 0 {
+		for _, v := range c.Env.stringList {
+			name, value, _ := strings.Cut(v, "=")
+			fmt.Fprintf(&setup, "export %s=%s\n", name, shellDoubleQuote(value))
+		}
+		setup.WriteString("\n")
+	}
+
+	// 3. Command; multi-task jobs launch their ranks with srun
+	cmd := containerCommand(job.Command, c)
+	if multiTask(c) {
+		cmd = "srun " + cmd
+	}
+	writeOriginal(&command, job.original())
+	writePrettyCommand(&command, cmd, c.InjectThreads == "rewrite")
+	if c.Retries > 0 {
+		retried := retryCommand(command.String(), c)
+		command.Reset()
+		command.WriteString(retried)
+	}
+	if c.ProfileCmd {
+		profiled := profileCommand(command.String())
+		command.Reset()
+		command.WriteString(profiled)
+	}
+	if c.VerifyOutputs {
+		writeVerifyOutputs(&command, job.Command)
+	}
+	if c.Checksums {
+		writeChecksums(&command, job.Command)
+	}
+
+	return ScriptData{
+		JobName:    jobName,
+		Command:    signalCommand(command.String(), c),
+		RawCommand: job.Command,
+		Header:     header.String(),
+		Setup:      setup.String(),
+		Shebang:    shebang(c),
+		Line:       job.Line,
+		Config:     c,
+	}
+}
+
+// packing runs the commands of a chunk Width at a time instead of one after another
+type packing struct {
+	Mode  string // "parallel" or "srun"
+	Width int
+}
+
+// Packing modes accepted by --pack
+var packModes = []string{"parallel", "srun"}
+
+// parsePack reads a --pack value like parallel:4; empty means no packing
+func parsePack(s string) (packing, error) {
+	if s == "" {
+		return packing{}, nil
+	}
+	mode, width, _ := strings.Cut(s, ":")
+	n, err := strconv.Atoi(width)
+	if !slices.Contains(packModes, mode) || err != nil || n < 1 {
+		return packing{}, fmt.Errorf("invalid --pack %q (use %s:K with K >= 1)", s, strings.Join(packModes, ":K or "))
+	}
+	return packing{Mode: mode, Width: n}, nil
+}
+
+// newChunkData renders a script that runs several jobs. Resources and setup come
+// from the first job; packed chunks get its CPUs once per concurrent command.
+func newChunkData(jobs []Job, members []int, name string, pack packing) (ScriptData, error) {
+	first := jobs[members[0]]
+	if pack.Mode != "" {
+		first.Conf.CPUs *= pack.Width
+	}
+	raw := make([]string, len(members))
+	for k, i := range members {
+		raw[k] = jobs[i].Command
+	}
+	// The setup links the inputs of every command into a --job-workdir
+	merged := first
+	merged.Command = strings.Join(raw, "\n")
+	d := newScriptData(merged, name)
+
+	var command strings.Builder
+
+	switch pack.Mode {
+	case "parallel":
+		if err := writeParallelCommands(&command, jobs, members, pack.Width); err != nil {
+			return d, err
+		}
+	case "srun":
+		writeSrunCommands(&command, jobs, members, pack.Width)
+	default:
+		// Sequential; the first failure ends the chunk
+		for k, i := range members {
+			if k > 0 {
+				command.WriteString("\n")
+			}
+			fmt.Fprintf(&command, "echo \"[$(date)] Task %d/%d (line %d)\"\n", k+1, len(members), jobs[i].Line)
+			cmd := containerCommand(jobs[i].Command, jobs[i].Conf)
+			if multiTask(jobs[i].Conf) {
+				cmd = "srun " + cmd
+			}
+			writeOriginal(&command, jobs[i].original())
+			writePrettyCommand(&command, cmd, jobs[i].Conf.InjectThreads == "rewrite")
+		}
+	}
+
+	if first.Conf.Retries > 0 {
+		retried := retryCommand(command.String(), first.Conf)
+		command.Reset()
+		command.WriteString(retried)
+	}
+	if first.Conf.ProfileCmd {
+		profiled := profileCommand(command.String())
+		command.Reset()
+		command.WriteString(profiled)
+	}
+	if first.Conf.VerifyOutputs {
+		writeVerifyOutputs(&command, merged.Command)
+	}
+	if first.Conf.Checksums {
+		writeChecksums(&command, merged.Command)
+	}
+	d.Command = signalCommand(command.String(), d.Config)
+	d.RawCommand = strings.Join(raw, "\n")
+	return d, nil
+}
+
+// tasksMarker ends the heredoc that feeds packed commands to GNU parallel
+const tasksMarker = "SLURMIFY_TASKS"
+
+// writeParallelCommands runs the commands width at a time with GNU parallel, or xargs
+// where parallel is not installed. Every command runs; any failure fails the job.
+func writeParallelCommands(sb *strings.Builder, jobs []Job, members []int, width int) error {
+	sb.WriteString("tasks() {\n")
+	fmt.Fprintf(sb, "  cat <<'%s'\n", tasksMarker)
+	for _, i := range members {
+		cmd := strings.TrimSpace(containerCommand(jobs[i].Command, jobs[i].Conf))
+		if strings.Contains(cmd, "\n") || cmd == tasksMarker {
+			return fmt.Errorf("%s: line %d: packed commands must fit on one line", sourceName(jobs[i].Source), jobs[i].Line)
+		}
+		sb.WriteString(cmd + "\n")
+	}
+	sb.WriteString(tasksMarker + "\n}\n")
+	fmt.Fprintf(sb, "echo \"[$(date)] Running %d tasks, %d at a time\"\n", len(members), width)
+	sb.WriteString("if command -v parallel >/dev/null; then\n")
+	fmt.Fprintf(sb, "  tasks | parallel --jobs %d\n", width)
+	sb.WriteString("else\n")
+	fmt.Fprintf(sb, "  tasks | xargs -d '\\n' -P %d -n 1 bash -c 'eval \"$1\"' _\n", width)
+	sb.WriteString("fi\n")
+	return nil
+}
+
+// writeSrunCommands starts every command as its own job step and waits for all
+// of them. Each step gets the CPUs of one command and a width-th of the memory;
+// Slurm holds steps back until a slot of the allocation is free.
+func writeSrunCommands(sb *strings.Builder, jobs []Job, members []int, width int) {
+	c := jobs[members[0]].Conf
+	step := fmt.Sprintf("srun --exact --ntasks=1 --cpus-per-task=%d", c.CPUs)
+	if c.MemPerCPU != "" {
+		step += " --mem-per-cpu=" + c.MemPerCPU
+	} else if kib, err := parseMem(c.Mem); err == nil {
+		step += " --mem=" + formatMem(kib/int64(width))
+	}
+
+	fmt.Fprintf(sb, "echo \"[$(date)] Running %d tasks as job steps, %d at a time\"\n", len(members), width)
+	sb.WriteString("pids=()\n")
+	for _, i := range members {
+		fmt.Fprintf(sb, "%s bash -c %s &\n", step, quoteArg(containerCommand(jobs[i].Command, jobs[i].Conf)))
+		sb.WriteString("pids+=($!)\n")
+	}
+	sb.WriteString("failed=0\n")
+	sb.WriteString("for pid in \"${pids[@]}\"; do\n")
+	sb.WriteString("  wait \"$pid\" || failed=$((failed + 1))\n")
+	sb.WriteString("done\n")
+	fmt.Fprintf(sb, "echo \"[$(date)] $failed of %d tasks failed\"\n", len(members))
+	sb.WriteString("[ \"$failed\" -eq 0 ]\n")
+}
+
+// exitHooks lists the shell lines the EXIT trap runs, in order. They run
+// whether the command succeeds or fails and can read its exit code as $status.
+func exitHooks(c Config) []string {
+	var hooks []string
+	if gpuMonitored(c) {
+		hooks = append(hooks, `kill "${gpu_monitor:-}" 2>/dev/null || true`)
+	}
+	if c.Cleanup != "" {
+		// Grouped so that a failing cleanup step neither skips the rest nor changes the status
+		hooks = append(hooks, fmt.Sprintf("{ %s; } || true", strings.TrimRight(c.Cleanup, "; ")))
+	}
+	if c.Seff {
+		hooks = append(hooks,
+			`echo "[$(date)] Efficiency report"`,
+			`if command -v seff >/dev/null; then seff "$SLURM_JOB_ID"; else sacct -j "$SLURM_JOB_ID" --format=JobID,Elapsed,TotalCPU,MaxRSS,ReqMem,State; fi || true`)
+	}
+	if c.Tmp != "" {
+		hooks = append(hooks, `if [[ -z "${SLURM_TMPDIR:-}" ]]; then rm -rf "$TMPDIR"; fi`)
+	}
+	if c.JobWorkdir != "" {
+		hooks = append(hooks,
+			`(cd "$workdir" && find . -type f ! -name outputs.txt | sort > outputs.txt) || true`,
+			`echo "[$(date)] Files written by the job are listed in $workdir/outputs.txt"`)
+	}
+	return append(hooks, notifyHooks(c)...)
+}
+
+// writeExitTrap wraps the hooks in a function trapped on EXIT that keeps the command's exit code
+func writeExitTrap(sb *strings.Builder, hooks []string) {
+	if len(hooks) == 0 {
+		return
+	}
+	sb.WriteString("on_exit() {\n")
+	sb.WriteString("  status=$?\n")
+	for _, hook := range hooks {
+		sb.WriteString("  " + hook + "\n")
+	}
+	sb.WriteString("  exit \"$status\"\n")
+	sb.WriteString("}\n")
+	sb.WriteString("trap on_exit EXIT\n\n")
+}
+
+// writeActivation activates the software environment the command runs in. The
+// activation scripts read unset variables, so strict mode pauses around them.
+func writeActivation(sb *strings.Builder, c Config) {
+	var lines []string
+	if c.Conda != "" {
+		lines = append(lines,
+			`source "$(conda info --base)/etc/profile.d/conda.sh"`,
+			"conda activate "+quoteArg(c.Conda))
+	}
+	if c.Micromamba != "" {
+		hook := `eval "$(micromamba shell hook -s bash)"`
+		if isZsh(c) {
+			hook = `eval "$(micromamba shell hook -s zsh)"`
+		}
+		lines = append(lines,
+			hook,
+			"micromamba activate "+quoteArg(c.Micromamba))
+	}
+	if c.SpackEnv != "" || len(c.SpackLoad.stringList) > 0 {
+		// spack env and spack load need the shell support, not just the binary;
+		// typeset -f finds the function in bash and zsh alike
+		lines = append(lines, `typeset -f spack >/dev/null || source "$SPACK_ROOT/share/spack/setup-env.sh"`)
+	}
+	if c.SpackEnv != "" {
+		lines = append(lines, "spack env activate "+quoteArg(c.SpackEnv))
+	}
+	for _, spec := range c.SpackLoad.stringList {
+		lines = append(lines, "spack load "+spec)
+	}
+	if c.Venv != "" {
+		lines = append(lines, "source "+scriptPath(filepath.Join(c.Venv, "bin", "activate"), c))
+	}
+	if len(lines) == 0 {
+		return
+	}
+	sb.WriteString("set +u\n")
+	for _, line := range lines {
+		sb.WriteString(line + "\n")
+	}
+	sb.WriteString("set -u\n\n")
+}
+
+// GPU metrics --gpu-monitor samples with nvidia-smi, once a minute
+const (
+	gpuMetrics         = "timestamp,index,name,utilization.gpu,utilization.memory,memory.used,memory.total,temperature.gpu,power.draw"
+	gpuMonitorInterval = 60
+)
+
+// writeGPUPreflight fails a GPU job at once when it cannot see its GPUs,
+// rather than letting the command fall back to the CPU for hours
+func writeGPUPreflight(sb *strings.Builder) {
+	sb.WriteString("if ! nvidia-smi >/dev/null 2>&1; then\n")
+	sb.WriteString("  echo \"[$(date)] nvidia-smi is not available, stopping before the command runs without GPUs\" >&2\n")
+	sb.WriteString("  exit 1\n")
+	sb.WriteString("fi\n")
+	sb.WriteString("if [[ -z \"${CUDA_VISIBLE_DEVICES:-}\" ]]; then\n")
+	sb.WriteString("  echo \"[$(date)] CUDA_VISIBLE_DEVICES is empty, stopping before the command runs without GPUs\" >&2\n")
+	sb.WriteString("  exit 1\n")
+	sb.WriteString("fi\n")
+}
+
+// gpuMonitored reports whether a job samples its GPU usage
+func gpuMonitored(c Config) bool {
+	return c.GPUMonitor && gpuCount(c.Gres) > 0
+}
+
+// writeGPUMonitor starts nvidia-smi in the background, writing a CSV trace next
+// to the job's logs; the exit trap stops it
+func writeGPUMonitor(sb *strings.Builder, jobName string, c Config) {
+	trace := scriptPath(filepath.Join(c.LogsDir, jobName), c) + "_${SLURM_JOB_ID}_gpu.csv"
+	fmt.Fprintf(sb, "nvidia-smi --query-gpu=%s --format=csv -l %d > %s &\n", gpuMetrics, gpuMonitorInterval, trace)
+	sb.WriteString("gpu_monitor=$!\n\n")
+}
+
+// writeJobWorkdir moves a job into its own directory under --job-workdir. Input
+// files named with relative paths are linked in so that the command finds them.
+func writeJobWorkdir(sb *strings.Builder, jobName, cmd string, c Config) {
+	fmt.Fprintf(sb, "workdir=%s\n", scriptPath(filepath.Join(c.JobWorkdir, jobName), c))
+	sb.WriteString("mkdir -p \"$workdir\"\n")
+	for _, path := range inputFiles(cmd, c) {
+		// Absolute paths work from anywhere; paths above the directory cannot be linked
+		path = filepath.Clean(path)
+		if filepath.IsAbs(path) || strings.HasPrefix(path, "..") {
+			continue
+		}
+		link := "\"$workdir\"/" + quoteArg(path)
+		if dir := filepath.Dir(path); dir != "." {
+			fmt.Fprintf(sb, "mkdir -p \"$workdir\"/%s\n", quoteArg(dir))
+		}
+		fmt.Fprintf(sb, "ln -sfn %s %s\n", scriptPath(path, c), link)
+	}
+	sb.WriteString("cd \"$workdir\"\n")
+}
+
+// batchSignal is the signal name of a --signal sent to the batch shell (B:), or empty
+func batchSignal(c Config) string {
+	spec, ok := strings.CutPrefix(c.Signal, "B:")
+	if !ok {
+		return ""
+	}
+	sig, _, _ := strings.Cut(spec, "@")
+	return strings.TrimPrefix(sig, "SIG")
+}
+
+// Signal a --checkpointable job gets when none is given: USR1 five minutes before the limit
+const checkpointSignal = "B:USR1@300"
+
+// checkpointConfig completes the settings a --checkpointable job needs: a signal
+// the script traps, requeueing allowed and logs appended across restarts
+func checkpointConfig(c Config) Config {
+	switch {
+	case c.Signal == "":
+		c.Signal = checkpointSignal
+	case batchSignal(c) == "":
+		c.Signal = "B:" + c.Signal
+	}
+	if c.Requeue == "" {
+		c.Requeue = "requeue"
+	}
+	if c.OpenMode == "" {
+		c.OpenMode = "append"
+	}
+	return c
+}
+
+// writeSignalTrap adds the handler for a B: --signal: it runs --on-signal and
+// passes the signal on to the processes of the command so they can save their state
+func writeSignalTrap(sb *strings.Builder, c Config) {
+	sig := batchSignal(c)
+	if sig == "" {
+		return
+	}
+	sb.WriteString("on_signal() {\n")
+	fmt.Fprintf(sb, "  echo \"[$(date)] Caught SIG%s, the time limit is near\"\n", sig)
+	if c.OnSignal != "" {
+		sb.WriteString("  " + c.OnSignal + " || true\n")
+	}
+	fmt.Fprintf(sb, "  pkill -%s -P \"$child\" 2>/dev/null || true\n", sig)
+	if c.Checkpointable {
+		fmt.Fprintf(sb, "  if (( ${SLURM_RESTART_COUNT:-0} < %d )); then\n", c.MaxRequeues)
+		sb.WriteString("    echo \"[$(date)] Requeueing the job\"\n")
+		sb.WriteString("    scontrol requeue \"$SLURM_JOB_ID\" || echo \"[$(date)] Could not requeue the job\"\n")
+		sb.WriteString("  else\n")
+		fmt.Fprintf(sb, "    echo \"[$(date)] Not requeueing, the job was already requeued %d times\"\n", c.MaxRequeues)
+		sb.WriteString("  fi\n")
+	}
+	sb.WriteString("}\n")
+	fmt.Fprintf(sb, "trap on_signal %s\n\n", sig)
+}
+
+// signalCommand runs the command in the background when a B: --signal is
+// handled, since bash only runs traps between commands, and waits for it. The
+// background shell ignores the signal so that only programs that handle it see it.
+func signalCommand(command string, c Config) string {
+	sig := batchSignal(c)
+	if sig == "" {
+		return command
+	}
+	var sb strings.Builder
+	sb.WriteString("{\n")
+	fmt.Fprintf(&sb, "trap '' %s\n", sig)
+	sb.WriteString(command)
+	sb.WriteString("} &\n")
+	sb.WriteString("child=$!\n")
+	sb.WriteString("status=0\n")
+	sb.WriteString("wait \"$child\" || status=$?\n")
+	sb.WriteString("# The signal interrupts wait; keep waiting while the command runs\n")
+	sb.WriteString("while kill -0 \"$child\" 2>/dev/null; do\n")
+	sb.WriteString("  status=0\n")
+	sb.WriteString("  wait \"$child\" || status=$?\n")
+	sb.WriteString("done\n")
+	sb.WriteString("exit \"$status\"\n")
+	return sb.String()
+}
+
+// writeCommandFunction defines the command as an exported shell function, so
+// that a fresh bash can run it with strict mode in effect
+func writeCommandFunction(sb *strings.Builder, name, command string) {
+	fmt.Fprintf(sb, "%s() {\n", name)
+	sb.WriteString(command)
+	sb.WriteString("}\n")
+	fmt.Fprintf(sb, "export -f %s\n", name)
+}
+
+// retryCommand reruns a failing command up to c.Retries more times, doubling
+// the pause between attempts from c.RetryDelay seconds
+func retryCommand(command string, c Config) string {
+	var sb strings.Builder
+	writeCommandFunction(&sb, "try_command", command)
+	sb.WriteString("attempt=1\n")
+	fmt.Fprintf(&sb, "delay=%d\n", c.RetryDelay)
+	sb.WriteString("until bash -euo pipefail -c try_command; do\n")
+	sb.WriteString("  status=$?\n")
+	fmt.Fprintf(&sb, "  if (( attempt > %d )); then\n", c.Retries)
+	sb.WriteString("    echo \"[$(date)] Attempt $attempt failed with exit code $status, giving up\"\n")
+	sb.WriteString("    exit \"$status\"\n")
+	sb.WriteString("  fi\n")
+	sb.WriteString("  echo \"[$(date)] Attempt $attempt failed with exit code $status, retrying in ${delay}s\"\n")
+	sb.WriteString("  sleep \"$delay\"\n")
+	sb.WriteString("  attempt=$((attempt + 1))\n")
+	sb.WriteString("  delay=$((delay * 2))\n")
+	sb.WriteString("done\n")
+	return sb.String()
+}
+
+// profileCommand runs the command under /usr/bin/time -v and logs its exit
+// code, wall time and peak memory. The command becomes an exported function run
+// by a fresh bash, so pipelines and redirections are measured as a whole.
+func profileCommand(command string) string {
+	var sb strings.Builder
+	writeCommandFunction(&sb, "run_command", command)
+	sb.WriteString("profile=$(mktemp)\n")
+	sb.WriteString("start=$SECONDS\n")
+	sb.WriteString("status=0\n")
+	sb.WriteString("if [[ -x /usr/bin/time ]]; then\n")
+	sb.WriteString("  /usr/bin/time -v -o \"$profile\" bash -euo pipefail -c run_command || status=$?\n")
+	sb.WriteString("else\n")
+	sb.WriteString("  bash -euo pipefail -c run_command || status=$?\n")
+	sb.WriteString("fi\n")
+	sb.WriteString("echo \"[$(date)] Exit code: $status\"\n")
+	sb.WriteString("echo \"[$(date)] Wall time: $((SECONDS - start)) s\"\n")
+	sb.WriteString("if grep -q 'Maximum resident' \"$profile\"; then\n")
+	sb.WriteString("  echo \"[$(date)] Max RSS: $(awk -F': ' '/Maximum resident/ {print $2}' \"$profile\") KB\"\n")
+	sb.WriteString("fi\n")
+	sb.WriteString("rm -f \"$profile\"\n")
+	sb.WriteString("[[ \"$status\" -eq 0 ]] || exit \"$status\"\n")
+	return sb.String()
+}
+
+// containerBinds lists, as shell words, the --container-bind paths followed by
+// the directories the job touches: the logs directory and the directories of
+// the command's inputs and outputs. Only existing directories can be bound, so
+// output directories made by the job are left out; one inside another bound
+// directory is already visible.
+func containerBinds(cmd string, c Config) []string {
+	var binds []string
+	for _, bind := range c.ContainerBind {
+		binds = append(binds, quoteArg(bind))
+	}
+	// slurmify creates the logs directory before submitting
+	dirs := []string{absPath(c.LogsDir)}
+	for _, path := range append(inputFiles(cmd, c), checkedOutputs(cmd)...) {
+		dir := absPath(filepath.Dir(path))
+		if info, err := os.Stat(dir); err == nil && info.IsDir() && dir != "/" {
+			dirs = append(dirs, dir)
+		}
+	}
+	slices.Sort(dirs)
+	var last string
+	for _, dir := range dirs {
+		if last != "" && (dir == last || strings.HasPrefix(dir, last+"/")) {
+			continue
+		}
+		binds = append(binds, scriptPath(dir, c))
+		last = dir
+	}
+	return binds
+}
+
+// containerImage is the image apptainer runs as a shell word: a local file by
+// scriptPath, since the job may run in another directory, a registry image by
+// reference or, with --container-cache, the image file pulled from the registry
+// into the cache
+func containerImage(c Config) string {
+	if !strings.Contains(c.Container, "://") {
+		return scriptPath(c.Container, c)
+	}
+	if c.ContainerCache == "" {
+		return quoteArg(c.Container)
+	}
+	_, ref, _ := strings.Cut(c.Container, "://")
+	// docker://biocontainers/samtools:1.17 is cached as biocontainers_samtools_1.17.sif
+	name := strings.Map(func(r rune) rune {
+		if r == '/' || r == ':' || r == '@' {
+			return '_'
+		}
+		return r
+	}, ref) + ".sif"
+	return scriptPath(filepath.Join(c.ContainerCache, name), c)
+}
+
+// writeContainerPull pulls a registry image into --container-cache once for the
+// whole batch; a lock makes concurrent jobs wait for the first pull instead of
+// pulling the image themselves
+func writeContainerPull(sb *strings.Builder, c Config) {
+	if c.ContainerCache == "" || !strings.Contains(c.Container, "://") {
+		return
+	}
+	image := containerImage(c)
+	fmt.Fprintf(sb, "mkdir -p %s\n", scriptPath(c.ContainerCache, c))
+	fmt.Fprintf(sb, "if [[ ! -f %s ]]; then\n", image)
+	sb.WriteString("  (\n")
+	sb.WriteString("    flock 9\n")
+	fmt.Fprintf(sb, "    if [[ ! -f %s ]]; then\n", image)
+	fmt.Fprintf(sb, "      echo \"[$(date)] Pulling %s\"\n", c.Container)
+	fmt.Fprintf(sb, "      apptainer pull %s.$SLURM_JOB_ID %s\n", image, quoteArg(c.Container))
+	fmt.Fprintf(sb, "      mv %s.$SLURM_JOB_ID %s\n", image, image)
+	sb.WriteString("    fi\n")
+	fmt.Fprintf(sb, "  ) 9>%s.lock\n", image)
+	sb.WriteString("fi\n\n")
+}
+
+// Operators that chain several programs, which must all run inside a container
+var chainOperators = map[string]bool{"|": true, "&&": true, "||": true, ";": true}
+
+// containerCommand runs a command inside the --container image with apptainer.
+// Commands chaining several programs run through bash in the container;
+// redirections alone stay outside since they only touch the output streams.
+func containerCommand(cmd string, c Config) string {
+	if c.Container == "" {
+		return cmd
+	}
+	exec := []string{"apptainer", "exec"}
+	if gpuCount(c.Gres) > 0 {
+		exec = append(exec, "--"+c.ContainerGPU)
+	}
+	for _, bind := range containerBinds(cmd, c) {
+		exec = append(exec, "--bind", bind)
+	}
+	exec = append(exec, containerImage(c))
+	tokens, err := shlex.Split(cmd)
+	if err != nil || slices.ContainsFunc(tokens, func(t string) bool { return chainOperators[t] }) {
+		return strings.Join(exec, " ") + " bash -c " + quoteArg(cmd)
+	}
+	return strings.Join(exec, " ") + " " + cmd
+}
+
+// multiTask reports whether a job requests more than one task in total
+func multiTask(c Config) bool {
+	return totalTasks(c) > 1
+}
+
+// totalTasks is the task count of a job; a per-node count alone sets it, as in the header
+func totalTasks(c Config) int {
+	if c.NTasksPerNode > 0 && c.NTasks == 1 {
+		return c.NTasksPerNode * c.Nodes
+	}
+	return c.NTasks
+}
+
+// Interpreters of the --shell names
+var shells = map[string]string{
+	"bash":       "/bin/bash",
+	"bash-login": "/bin/bash -l",
+	"env":        "/usr/bin/env bash",
+	"zsh":        "/bin/zsh",
+}
+
+// shebang is the interpreter line of a script
+func shebang(c Config) string {
+	if path, ok := shells[c.Shell]; ok {
+		return "#!" + path + "\n"
+	}
+	return "#!" + c.Shell + "\n"
+}
+
+// isZsh reports whether the scripts run under zsh rather than bash
+func isZsh(c Config) bool {
+	for _, field := range strings.Fields(strings.TrimPrefix(shebang(c), "#!")) {
+		if filepath.Base(field) == "zsh" {
+			return true
+		}
+	}
+	return false
+}
+
+// strictMode stops the script on errors, unset variables and failed pipes;
+// zsh also splits unquoted variables the way bash does
+func strictMode(c Config) string {
+	if isZsh(c) {
+		return "setopt errexit nounset pipefail sh_word_split\n"
+	}
+	return "set -euo pipefail\n"
+}
+
+// generateScript builds the full content of the .sbatch file, through the
+// user template when one is given
+func generateScript(d ScriptData, tmpl *template.Template) (string, error) {
+	if tmpl != nil {
+		var sb strings.Builder
+		if err := tmpl.Execute(&sb, d); err != nil {
+			return "", fmt.Errorf("could not render template for %s: %w", d.JobName, err)
+		}
+		return sb.String(), nil
+	}
+	return d.Shebang + d.Provenance + d.Header + "\n" + d.Setup + "# Command\n" + d.Command, nil
+}
+
+// loadTemplate parses a --template file; no path means the built-in layout
+func loadTemplate(path string) (*template.Template, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read template: %w", err)
+	}
+	tmpl, err := template.New(filepath.Base(path)).
+		Option("missingkey=error").
+		Funcs(template.FuncMap{"quote": quoteArg}).
+		Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// writeSbatchHeader handles the #SBATCH lines
+func writeSbatchHeader(sb *strings.Builder, jobName string, c Config) {
+	fmt.Fprintf(sb, "#SBATCH --job-name=%s\n", jobName)
+	fmt.Fprintf(sb, "#SBATCH --account=%s\n", c.Account)
+	fmt.Fprintf(sb, "#SBATCH --partition=%s\n", c.Partition)
+	if c.partitionNote != "" && !c.Deterministic {
+		fmt.Fprintf(sb, "# Partition chosen by --partition auto: %s\n", c.partitionNote)
+	}
+	if c.QOS != "" {
+		fmt.Fprintf(sb, "#SBATCH --qos=%s\n", c.QOS)
+	}
+	if c.Constraint != "" {
+		fmt.Fprintf(sb, "#SBATCH --constraint=%s\n", c.Constraint)
+	}
+	if c.Reservation != "" {
+		fmt.Fprintf(sb, "#SBATCH --reservation=%s\n", c.Reservation)
+	}
+	if c.Licenses != "" {
+		fmt.Fprintf(sb, "#SBATCH --licenses=%s\n", c.Licenses)
+	}
+	if c.Exclusive {
+		sb.WriteString("#SBATCH --exclusive\n")
+	}
+	if c.Tmp != "" {
+		fmt.Fprintf(sb, "#SBATCH --tmp=%s\n", c.Tmp)
+	}
+	if c.NodeList != "" {
+		fmt.Fprintf(sb, "#SBATCH --nodelist=%s\n", c.NodeList)
+	}
+	if c.Exclude != "" {
+		fmt.Fprintf(sb, "#SBATCH --exclude=%s\n", c.Exclude)
+	}
+	if c.Requeue != "" {
+		fmt.Fprintf(sb, "#SBATCH --%s\n", c.Requeue)
+	}
+	if c.OpenMode != "" {
+		fmt.Fprintf(sb, "#SBATCH --open-mode=%s\n", c.OpenMode)
+	}
+	if c.Hold {
+		sb.WriteString("#SBATCH --hold\n")
+	}
+	if c.Nice != 0 {
+		fmt.Fprintf(sb, "#SBATCH --nice=%d\n", c.Nice)
+	}
+	if c.Begin != "" {
+		fmt.Fprintf(sb, "#SBATCH --begin=%s\n", c.Begin)
+	}
+	if c.Deadline != "" {
+		fmt.Fprintf(sb, "#SBATCH --deadline=%s\n", c.Deadline)
+	}
+	if c.Signal != "" {
+		fmt.Fprintf(sb, "#SBATCH --signal=%s\n", c.Signal)
+	}
+	if c.Export != "" {
+		fmt.Fprintf(sb, "#SBATCH --export=%s\n", c.Export)
+	}
+	if c.Chdir != "" {
+		fmt.Fprintf(sb, "#SBATCH --chdir=%s\n", sbatchPath(c.Chdir, c))
+	}
+	fmt.Fprintf(sb, "#SBATCH --nodes=%d\n", c.Nodes)
+	// A per-node count alone sets the total; --ntasks would cap it
+	if c.NTasksPerNode == 0 || c.NTasks != 1 {
+		fmt.Fprintf(sb, "#SBATCH --ntasks=%d\n", c.NTasks)
+	}
+	if c.NTasksPerNode > 0 {
+		fmt.Fprintf(sb, "#SBATCH --ntasks-per-node=%d\n", c.NTasksPerNode)
+	}
+	fmt.Fprintf(sb, "#SBATCH --cpus-per-task=%d\n", c.CPUs)
+	if c.MemPerCPU != "" {
+		fmt.Fprintf(sb, "#SBATCH --mem-per-cpu=%s\n", c.MemPerCPU)
+	} else {
+		fmt.Fprintf(sb, "#SBATCH --mem=%s\n", c.Mem)
+	}
+	fmt.Fprintf(sb, "#SBATCH --time=%s\n", c.Time)
+	// Log paths are relative to the working directory of the job
+	logs := c.LogsDir
+	if c.Chdir != "" {
+		logs = sbatchPath(logs, c)
+		if dir := sbatchPath(c.Chdir, c); !filepath.IsAbs(logs) && !filepath.IsAbs(dir) {
+			logs, _ = filepath.Rel(dir, logs)
+		}
+	}
+	fmt.Fprintf(sb, "#SBATCH --output=%s/%s_%%j.out\n", logs, jobName)
+	fmt.Fprintf(sb, "#SBATCH --error=%s/%s_%%j.err\n", logs, jobName)
+
+	switch {
+	case c.Gpus != "" && c.GPUDirective == "gpus-per-node":
+		fmt.Fprintf(sb, "#SBATCH --gpus-per-node=%s\n", c.Gpus)
+	case c.Gres != "":
+		fmt.Fprintf(sb, "#SBATCH --gres=%s\n", c.Gres)
+	}
+	if c.Email != "" && !c.Sentinel {
+		fmt.Fprintf(sb, "#SBATCH --mail-user=%s\n", c.Email)
+		fmt.Fprintf(sb, "#SBATCH --mail-type=%s\n", c.MailType)
+	}
+
+	// Passthrough directives go last so they can override anything above
+	for _, directive := range c.Sbatch {
+		fmt.Fprintf(sb, "#SBATCH %s\n", directive)
+	}
+}
+
+// Flags whose integer value is a thread count for common tools
+var threadFlags = map[string]bool{"-t": true, "--threads": true, "-@": true, "-p": true}
+
+// threadsVar is the allocated CPU count, double-quoted so the shell expands it as one word
+const threadsVar = `"$SLURM_CPUS_PER_TASK"`
+
+// isInteger reports whether s is a plain non-negative number
+func isInteger(s string) bool {
+	_, err := strconv.Atoi(s)
+	return err == nil && !strings.HasPrefix(s, "-") && !strings.HasPrefix(s, "+")
+}
+
+// writeOriginal records the input line as a comment, so a reviewer can check
+// that quoting survived the reformatting below it
+func writeOriginal(sb *strings.Builder, cmd string) {
+	for _, line := range strings.Split(strings.TrimSpace(cmd), "\n") {
+		fmt.Fprintf(sb, "# original: %s\n", line)
+	}
+}
+
+// writePrettyCommand splits the command into words and breaks the lines; with
+// threads, integer thread flags are set to the allocated CPUs
+func writePrettyCommand(sb *strings.Builder, cmd string, threads bool) {
+	words, err := splitWords(cmd)
+	if err != nil {
+		// Fallback to raw string if quotes are unbalanced
+		sb.WriteString(cmd + "\n")
+		return
+	}
+
+	var lines []string
+	i := 0
+	for i < len(words) {
+		token := words[i].Value
+		curr := words[i].render()
+
+		if threads && i > 0 {
+			if threadFlags[token] && i+1 < len(words) && isInteger(words[i+1].Value) {
+				lines = append(lines, token+" "+threadsVar)
+				i += 2
+				continue
+			}
+			if name, value, ok := strings.Cut(token, "="); ok && threadFlags[name] && isInteger(value) {
+				lines = append(lines, name+"="+threadsVar)
+				i++
+				continue
+			}
+		}
+
+		// Check if this is a short/long flag followed by a separate value.
+		// Skip if the flag already embeds its value (e.g. --output=file.bam).
+		if strings.HasPrefix(curr, "-") && !strings.Contains(curr, "=") && i+1 < len(words) {
+			next := words[i+1].Value
+			if !strings.HasPrefix(next, "-") && !isShellOperator(next) {
+				curr = fmt.Sprintf("%s %s", curr, words[i+1].render())
+				i++
+			}
+		}
+		lines = append(lines, curr)
+		i++
+	}
+
+	sb.WriteString(strings.Join(lines, " \\\n  ") + "\n")
+}
+
+// shellWord is a word of a command line, as written and with its quoting removed
+type shellWord struct {
+	Raw    string
+	Value  string
+	Expand bool // a $ or backtick expansion outside single quotes
+}
+
+// render is the word for the script: words that expand variables or commands
+// are kept as written so they still expand in the job, the rest are requoted
+func (w shellWord) render() string {
+	switch {
+	case w.Expand:
+		return w.Raw
+	case isShellOperator(w.Value):
+		return w.Value
+	}
+	return quoteArg(w.Value)
+}
+
+// errUnbalanced is returned for commands whose quotes or expansions do not close
+var errUnbalanced = errors.New("unbalanced quotes or expansion")
+
+// splitWords splits a command line into words like the shell, remembering how
+// each was written; like shlex, a word starting with # starts a comment
+func splitWords(cmd string) ([]shellWord, error) {
+	rs := []rune(cmd)
+	var words []shellWord
+	var w shellWord
+	var raw, value strings.Builder
+	inWord := false
+	flush := func() {
+		if inWord {
+			w.Raw, w.Value = raw.String(), value.String()
+			words = append(words, w)
+		}
+		w = shellWord{}
+		raw.Reset()
+		value.Reset()
+		inWord = false
+	}
+
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		if unicode.IsSpace(r) {
+			flush()
+			continue
+		}
+		if r == '#' && !inWord {
+			break
+		}
+		inWord = true
+		start := i
+		switch r {
+		case '\\':
+			if i+1 == len(rs) {
+				return nil, errUnbalanced
+			}
+			i++
+			value.WriteRune(rs[i])
+		case '\'':
+			end := slices.Index(rs[i+1:], '\'')
+			if end < 0 {
+				return nil, errUnbalanced
+			}
+			i += end + 1
+			value.WriteString(string(rs[start+1 : i]))
+		case '"':
+			for i++; i < len(rs) && rs[i] != '"'; i++ {
+				switch {
+				case rs[i] == '\\' && i+1 < len(rs) && strings.ContainsRune("\\\"$`", rs[i+1]):
+					i++
+					value.WriteRune(rs[i])
+				case rs[i] == '$' || rs[i] == '`':
+					end := expansionEnd(rs, i)
+					if end < 0 {
+						return nil, errUnbalanced
+					}
+					w.Expand = true
+					value.WriteString(string(rs[i : end+1]))
+					i = end
+				default:
+					value.WriteRune(rs[i])
+				}
+			}
+			if i == len(rs) {
+				return nil, errUnbalanced
+			}
+		case '$', '`':
+			end := expansionEnd(rs, i)
+			if end < 0 {
+				return nil, errUnbalanced
+			}
+			w.Expand = true
+			i = end
+			value.WriteString(string(rs[start : i+1]))
+		default:
+			value.WriteRune(r)
+		}
+		raw.WriteString(string(rs[start : i+1]))
+	}
+	flush()
+	return words, nil
+}
+
+// expansionEnd is the index of the last rune of the expansion starting at
+// rs[i]: a backtick command, $(...), ${...}, or just the $ of $VAR, whose name
+// follows as plain text. It is -1 when the expansion does not close.
+func expansionEnd(rs []rune, i int) int {
+	if rs[i] == '`' {
+		if end := slices.Index(rs[i+1:], '`'); end >= 0 {
+			return i + 1 + end
+		}
+		return -1
+	}
+	if i+1 == len(rs) || (rs[i+1] != '(' && rs[i+1] != '{') {
+		return i
+	}
+	opening, closing := rs[i+1], map[rune]rune{'(': ')', '{': '}'}[rs[i+1]]
+	depth := 0
+	quote := rune(0)
+	for j := i + 1; j < len(rs); j++ {
+		switch {
+		case quote != 0:
+			if rs[j] == quote {
+				quote = 0
+			}
+		case rs[j] == '\'' || rs[j] == '"':
+			quote = rs[j]
+		case rs[j] == '\\':
+			j++
+		case rs[j] == opening:
+			depth++
+		case rs[j] == closing:
+			if depth--; depth == 0 {
+				return j
+			}
+		}
+	}
+	return -1
+}
+
+// shellDoubleQuote quotes s for bash while keeping $ expansions, e.g. "$HOME/tmp"
+func shellDoubleQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func quoteArg(s string) string {
+	if s == "" {
+		return "''"
+	}
+	if safeArgPattern.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", "'\\''") + "'"
+}
+
+// isShellOperator uses a switch for O(1)
+func isShellOperator(s string) bool {
+	switch s {
+	case ">", ">>", "<", "|", "2>", "1>", "&>", "&&", "||", ";":
+		return true
+	}
+	return false
+}
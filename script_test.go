@@ -0,0 +1,66 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSplitWords(t *testing.T) {
+	plain := func(raw, value string) shellWord { return shellWord{Raw: raw, Value: value} }
+	expand := func(raw, value string) shellWord { return shellWord{Raw: raw, Value: value, Expand: true} }
+	echo := plain("echo", "echo")
+	tests := []struct {
+		cmd  string
+		want []shellWord
+	}{
+		{"echo a  b", []shellWord{echo, plain("a", "a"), plain("b", "b")}},
+		{"echo 'a b'", []shellWord{echo, plain("'a b'", "a b")}},
+		{`echo "a b"`, []shellWord{echo, plain(`"a b"`, "a b")}},
+		{`echo a\ b`, []shellWord{echo, plain(`a\ b`, "a b")}},
+		{`echo "a\"b\\"`, []shellWord{echo, plain(`"a\"b\\"`, `a"b\`)}},
+		{`echo "a\nb"`, []shellWord{echo, plain(`"a\nb"`, `a\nb`)}},
+		{`echo pre'a b'"c d"`, []shellWord{echo, plain(`pre'a b'"c d"`, "prea bc d")}},
+		{"echo '$HOME'", []shellWord{echo, plain("'$HOME'", "$HOME")}},
+		{"echo $HOME/x", []shellWord{echo, expand("$HOME/x", "$HOME/x")}},
+		{`echo "$HOME/a b"`, []shellWord{echo, expand(`"$HOME/a b"`, "$HOME/a b")}},
+		{"echo $(date +%F)", []shellWord{echo, expand("$(date +%F)", "$(date +%F)")}},
+		{"echo ${x:-a b}", []shellWord{echo, expand("${x:-a b}", "${x:-a b}")}},
+		{"echo `date +%F`", []shellWord{echo, expand("`date +%F`", "`date +%F`")}},
+		{"echo $(basename $(pwd))", []shellWord{echo, expand("$(basename $(pwd))", "$(basename $(pwd))")}},
+		{`echo "$(echo ")")"`, []shellWord{echo, expand(`"$(echo ")")"`, `$(echo ")")`)}},
+		{"echo $(echo '(')", []shellWord{echo, expand("$(echo '(')", "$(echo '(')")}},
+		{"echo a # note", []shellWord{echo, plain("a", "a")}},
+		{"echo a#b", []shellWord{echo, plain("a#b", "a#b")}},
+		{"echo a > out", []shellWord{echo, plain("a", "a"), plain(">", ">"), plain("out", "out")}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		got, err := splitWords(tt.cmd)
+		if err != nil {
+			t.Errorf("splitWords(%q): %v", tt.cmd, err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("splitWords(%q) = %+v, want %+v", tt.cmd, got, tt.want)
+		}
+	}
+}
+
+func TestSplitWordsUnbalanced(t *testing.T) {
+	for _, cmd := range []string{
+		"echo 'a", `echo "a`, `echo a\`, "echo $(date", "echo ${x", "echo `date",
+		`echo "$(date"`, "echo $(basename $(pwd)", `echo "a\"`,
+	} {
+		if _, err := splitWords(cmd); err == nil {
+			t.Errorf("splitWords(%q) accepted an unbalanced command", cmd)
+		}
+	}
+}
+
+// --check rejects what the script writer cannot split
+func TestCheckUsesScriptTokenizer(t *testing.T) {
+	job := Job{Command: "echo $(date", Conf: defaultConfig()}
+	if len(jobProblems(job)) == 0 {
+		t.Error("unclosed $( passed the check")
+	}
+}
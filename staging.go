@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/shlex"
+)
+
+// --- STAGING ---
+
+// Flags whose value is an output file of common tools
+var outputFlags = map[string]bool{"-o": true, "--output": true, "--out": true}
+
+// outputFiles lists the files a command writes: redirection targets and the
+// values of -o, --output and --out
+func outputFiles(cmd string) []string {
+	tokens, err := shlex.Split(cmd)
+	if err != nil {
+		return nil
+	}
+	var files []string
+	seen := map[string]bool{}
+	add := func(path string) {
+		if path != "" && !seen[path] && !strings.HasPrefix(path, "-") && !isShellOperator(path) {
+			seen[path] = true
+			files = append(files, path)
+		}
+	}
+	for i := 1; i < len(tokens); i++ {
+		token := tokens[i]
+		if name, value, ok := strings.Cut(token, "="); ok && outputFlags[name] {
+			add(value)
+			continue
+		}
+		if (outputRedirects[token] || outputFlags[token]) && i+1 < len(tokens) {
+			add(tokens[i+1])
+			i++
+		}
+	}
+	return files
+}
+
+// localPaths keeps the relative paths that stay below the working directory,
+// the ones that can be mirrored into another directory
+func localPaths(paths []string) []string {
+	var local []string
+	for _, path := range paths {
+		path = filepath.Clean(path)
+		if !filepath.IsAbs(path) && !strings.HasPrefix(path, "..") && path != "." && path != "/dev/null" {
+			local = append(local, path)
+		}
+	}
+	return local
+}
+
+// quoteArgs quotes every path for the shell and joins them
+func quoteArgs(paths []string) string {
+	quoted := make([]string, len(paths))
+	for i, path := range paths {
+		quoted[i] = quoteArg(path)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// writeStageIn copies the inputs of a command to node-local scratch and moves
+// the job there; the exit hooks from stageOutHooks copy the outputs back
+func writeStageIn(sb *strings.Builder, cmd string, c Config) {
+	sb.WriteString("# Stage inputs to node-local scratch and run there\n")
+	sb.WriteString("stage_dir=$PWD\n")
+	sb.WriteString("scratch=\"${SLURM_TMPDIR:-${TMPDIR:-/tmp}}/slurmify_$SLURM_JOB_ID\"\n")
+	sb.WriteString("mkdir -p \"$scratch\"\n")
+	if inputs := localPaths(inputFiles(cmd, c)); len(inputs) > 0 {
+		fmt.Fprintf(sb, "rsync -aRL %s \"$scratch\"/\n", quoteArgs(inputs))
+	}
+	if dirs := outputDirs(cmd); len(dirs) > 0 {
+		fmt.Fprintf(sb, "(cd \"$scratch\" && mkdir -p %s)\n", quoteArgs(dirs))
+	}
+	sb.WriteString("cd \"$scratch\"\n")
+}
+
+// outputDirs lists the directories the outputs of a command are written to
+func outputDirs(cmd string) []string {
+	var dirs []string
+	seen := map[string]bool{}
+	for _, path := range localPaths(outputFiles(cmd)) {
+		if dir := filepath.Dir(path); dir != "." && !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// stageOutHooks copy the outputs of a command back from scratch, also after a
+// failure so that partial results are kept, and remove the scratch directory;
+// they run before the other hooks since scratch may sit inside $TMPDIR
+func stageOutHooks(cmd string, extra []string) []string {
+	outputs := localPaths(append(outputFiles(cmd), extra...))
+	var hooks []string
+	if len(outputs) > 0 {
+		hooks = append(hooks,
+			`echo "[$(date)] Copying outputs back from scratch"`,
+			fmt.Sprintf(`(cd "$scratch" && rsync -aR --ignore-missing-args %s "$stage_dir"/) || echo "[$(date)] Could not copy outputs back from $scratch"`, quoteArgs(outputs)))
+	}
+	return append(hooks, `cd "$stage_dir" && rm -rf "$scratch"`)
+}
+
+// --- OUTPUT CHECKS ---
+
+// checkedOutputs are the outputs of a command that can be tested for content
+func checkedOutputs(cmd string) []string {
+	var outputs []string
+	for _, path := range outputFiles(cmd) {
+		if !strings.HasPrefix(path, "/dev/") {
+			outputs = append(outputs, path)
+		}
+	}
+	return outputs
+}
+
+// writeVerifyOutputs fails the job when an output of the command is missing or
+// empty, so that a tool exiting 0 without writing its result is noticed
+func writeVerifyOutputs(sb *strings.Builder, cmd string) {
+	outputs := checkedOutputs(cmd)
+	if len(outputs) == 0 {
+		return
+	}
+	sb.WriteString("\n# Verify outputs\n")
+	fmt.Fprintf(sb, "for out in %s; do\n", quoteArgs(outputs))
+	sb.WriteString("  if [[ ! -s \"$out\" ]]; then\n")
+	sb.WriteString("    echo \"[$(date)] Output $out is missing or empty\" >&2\n")
+	sb.WriteString("    exit 1\n")
+	sb.WriteString("  fi\n")
+	sb.WriteString("done\n")
+	sb.WriteString("echo \"[$(date)] Outputs verified\"\n")
+}
+
+// checksumExt is appended to an output's name for its checksum sidecar
+const checksumExt = ".sha256"
+
+// writeChecksums stores the sha256 of every output of the command next to it,
+// in the format of sha256sum so that `sha256sum -c` checks it in that directory
+func writeChecksums(sb *strings.Builder, cmd string) {
+	outputs := checkedOutputs(cmd)
+	if len(outputs) == 0 {
+		return
+	}
+	sb.WriteString("\n# Checksums\n")
+	fmt.Fprintf(sb, "for out in %s; do\n", quoteArgs(outputs))
+	sb.WriteString("  if [[ -f \"$out\" ]]; then\n")
+	fmt.Fprintf(sb, "    (cd \"$(dirname \"$out\")\" && sha256sum \"$(basename \"$out\")\") > \"$out%s\"\n", checksumExt)
+	sb.WriteString("  fi\n")
+	sb.WriteString("done\n")
+}
+
+// checksumFiles are the sidecars writeChecksums creates for a command
+func checksumFiles(cmd string) []string {
+	var files []string
+	for _, path := range checkedOutputs(cmd) {
+		files = append(files, path+checksumExt)
+	}
+	return files
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// --- STATE DATABASE ---
+
+// stateSchema records generation runs, their scripts and every submission.
+// Paths are absolute so the database can be queried from any directory.
+const stateSchema = `
+CREATE TABLE IF NOT EXISTS runs (
+	id         INTEGER PRIMARY KEY,
+	started    TEXT NOT NULL,
+	cwd        TEXT NOT NULL,
+	args       TEXT NOT NULL,
+	output_dir TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS scripts (
+	id      INTEGER PRIMARY KEY,
+	run_id  INTEGER NOT NULL REFERENCES runs(id),
+	path    TEXT NOT NULL,
+	name    TEXT NOT NULL,
+	source  TEXT NOT NULL,
+	line    INTEGER NOT NULL,
+	command TEXT NOT NULL,
+	status  TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS submissions (
+	id        INTEGER PRIMARY KEY,
+	run_id    INTEGER REFERENCES runs(id),
+	script    TEXT NOT NULL,
+	job_id    TEXT NOT NULL,
+	submitted TEXT NOT NULL
+);`
+
+// openState opens the state database at path, creating it and its directory if needed
+func openState(path string) (*sql.DB, error) {
+	// Config files are not expanded by a shell
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.Join(home, rest)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("could not create state directory: %w", err)
+	}
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open state database: %w", err)
+	}
+	if _, err := db.Exec(stateSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not initialize state database %s: %w", path, err)
+	}
+	return db, nil
+}
+
+// recordState opens the database at path and stores a generation run
+func recordState(path, outputDir string, scripts []Script) (int64, error) {
+	db, err := openState(path)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	runID, err := recordRun(db, outputDir, scripts)
+	if err != nil {
+		return 0, fmt.Errorf("could not record run in %s: %w", path, err)
+	}
+	return runID, nil
+}
+
+// recordRun stores a generation run and its scripts, returning the run ID
+func recordRun(db *sql.DB, outputDir string, scripts []Script) (int64, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return 0, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("INSERT INTO runs (started, cwd, args, output_dir) VALUES (?, ?, ?, ?)",
+		time.Now().Format(time.RFC3339), cwd, strings.Join(os.Args[1:], " "), absPath(outputDir))
+	if err != nil {
+		return 0, err
+	}
+	runID, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	for _, s := range scripts {
+		_, err := tx.Exec("INSERT INTO scripts (run_id, path, name, source, line, command, status) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			runID, absPath(s.Path), s.Name, sourceName(s.Job.Source), s.Job.Line, s.Job.Command, scriptStatus(s))
+		if err != nil {
+			return 0, err
+		}
+	}
+	return runID, tx.Commit()
+}
+
+// recordStateSubmissions stores successful submissions; runID 0 means outside a generate run
+func recordStateSubmissions(db *sql.DB, runID int64, subs []Submission) error {
+	run := sql.NullInt64{Int64: runID, Valid: runID != 0}
+	for _, s := range subs {
+		if s.Err != nil {
+			continue
+		}
+		_, err := db.Exec("INSERT INTO submissions (run_id, script, job_id, submitted) VALUES (?, ?, ?, ?)",
+			run, absPath(s.Script), s.JobID, s.At.Format(time.RFC3339))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stateJobRecords returns the latest submission of every script in dir
+func stateJobRecords(db *sql.DB, dir string) ([]JobRecord, error) {
+	rows, err := db.Query(`SELECT job_id, script FROM submissions
+		WHERE id IN (SELECT MAX(id) FROM submissions GROUP BY script) AND script LIKE ? ESCAPE '\'
+		ORDER BY id`, likePrefix(absPath(dir)+string(filepath.Separator)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []JobRecord
+	for rows.Next() {
+		var r JobRecord
+		if err := rows.Scan(&r.JobID, &r.Script); err != nil {
+			return nil, err
+		}
+		r.Script = relPath(r.Script)
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+// likePrefix builds a LIKE pattern matching strings that start with prefix
+func likePrefix(prefix string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(prefix) + "%"
+}
+
+// absPath makes path absolute, leaving it as is if that fails
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// relPath shows an absolute path relative to the working directory when it is inside it
+func relPath(path string) string {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return path
+	}
+	if rel, err := filepath.Rel(cwd, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return path
+}
+
+// submitRel is path relative to the directory jobs are submitted from, the
+// working directory; false for absolute paths outside it
+func submitRel(path string) (string, bool) {
+	if filepath.IsAbs(path) {
+		path = relPath(path)
+	}
+	return filepath.Clean(path), !filepath.IsAbs(path)
+}
+
+// scriptPath is path as a shell word for a job script, which may run in another
+// directory: absolute, or with --deterministic anchored at $SLURM_SUBMIT_DIR
+// so that the script does not record where it was generated
+func scriptPath(path string, c Config) string {
+	if rel, ok := submitRel(path); ok && c.Deterministic {
+		if rel == "." {
+			return `"$SLURM_SUBMIT_DIR"`
+		}
+		return `"$SLURM_SUBMIT_DIR"/` + quoteArg(rel)
+	}
+	return quoteArg(absPath(path))
+}
+
+// sbatchPath is path for an #SBATCH line, which cannot expand variables; with
+// --deterministic a relative path, which sbatch takes from the submit directory
+func sbatchPath(path string, c Config) string {
+	if rel, ok := submitRel(path); ok && c.Deterministic {
+		return rel
+	}
+	return absPath(path)
+}
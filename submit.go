@@ -0,0 +1,566 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// --- SUBMISSION ---
+
+// jobsFileName records submitted job IDs inside the output directory
+const jobsFileName = "jobs.tsv"
+
+// submitWrapperName is the generated script that submits a batch in order
+const submitWrapperName = "submit_all.sh"
+
+// Submission records the outcome of a single sbatch call
+type Submission struct {
+	Script string
+	JobID  string
+	Err    error
+	At     time.Time
+}
+
+// JobRecord is one line of the jobs file
+type JobRecord struct {
+	JobID  string
+	Script string
+}
+
+// runSubmit submits existing scripts, by default the batch in the output dir
+// in the order of its manifest, so that dependencies are passed to sbatch
+func runSubmit(args []string) error {
+	conf := defaultConfig()
+	fset := newCommandFlags("submit", &conf)
+	fset.Float64Var(&conf.Throttle, "throttle", conf.Throttle, "Seconds to wait between submissions")
+	if err := parseArgs(fset, args, "slurmify submit [-O <dir>] [script.sbatch ...]"); err != nil {
+		return err
+	}
+
+	batch, err := readManifest(conf.OutputDir)
+	if err != nil {
+		return err
+	}
+	scripts := batch
+	if fset.NArg() > 0 {
+		scripts = selectScripts(batch, fset.Args())
+	} else if len(batch) == 0 {
+		// Batches without a manifest have no recorded dependencies
+		if scripts, err = globScripts(conf); err != nil {
+			return err
+		}
+	}
+	if len(scripts) == 0 {
+		return fmt.Errorf("no scripts to submit in %s/", conf.OutputDir)
+	}
+
+	subs := submitScripts(scripts, conf.Throttle)
+	printSubmissions(subs)
+	return recordAll(conf, 0, subs)
+}
+
+// selectScripts picks the given scripts, keeping the dependencies the manifest
+// lists between them; dependencies on scripts left out are dropped
+func selectScripts(batch []Script, paths []string) []Script {
+	byPath := map[string]Script{}
+	for _, s := range batch {
+		byPath[filepath.Clean(s.Path)] = s
+	}
+	scripts := make([]Script, 0, len(paths))
+	chosen := map[int]bool{}
+	for _, path := range paths {
+		s, ok := byPath[filepath.Clean(path)]
+		if !ok {
+			// Unknown scripts get indexes past the batch, with no dependencies
+			s = Script{Path: path, Index: len(batch) + len(scripts)}
+		}
+		chosen[s.Index] = true
+		scripts = append(scripts, s)
+	}
+	for i, s := range scripts {
+		after := slices.DeleteFunc(slices.Clone(s.After), func(idx int) bool { return !chosen[idx] })
+		if len(after) < len(s.After) {
+			fmt.Fprintf(os.Stderr, "[slurmify] Warning: %s depends on scripts not submitted now; submitting it without them\n", s.Path)
+		}
+		scripts[i].After = after
+	}
+	return scripts
+}
+
+// globScripts lists every script in the output dir by name
+func globScripts(conf Config) ([]Script, error) {
+	found, err := filepath.Glob(filepath.Join(conf.OutputDir, "*"+conf.Ext))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(found)
+	var scripts []Script
+	// With --ext .sh the glob also finds the batch's own helper scripts
+	for _, path := range found {
+		if name := filepath.Base(path); name != submitWrapperName && name != sentinelName {
+			scripts = append(scripts, Script{Path: path, Index: len(scripts)})
+		}
+	}
+	return scripts, nil
+}
+
+// recordAll appends job IDs to the jobs file and, with --state-db, to the state database
+func recordAll(conf Config, runID int64, subs []Submission) error {
+	if err := recordSubmissions(conf.OutputDir, subs); err != nil {
+		return fmt.Errorf("could not record job IDs: %w", err)
+	}
+	if conf.StateDB == "" {
+		return nil
+	}
+	db, err := openState(conf.StateDB)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := recordStateSubmissions(db, runID, subs); err != nil {
+		return fmt.Errorf("could not record job IDs in %s: %w", conf.StateDB, err)
+	}
+	return nil
+}
+
+// submitScripts runs sbatch on each script in order, wiring up afterok dependencies
+func submitScripts(scripts []Script, throttle float64) []Submission {
+	subs := make([]Submission, 0, len(scripts))
+	ids := map[int]string{}
+	for i, script := range scripts {
+		if i > 0 && throttle > 0 {
+			time.Sleep(time.Duration(throttle * float64(time.Second)))
+		}
+
+		var jobID string
+		deps, err := dependencyIDs(script.After, ids)
+		if err == nil {
+			jobID, err = sbatch(script.Path, deps)
+		}
+		if err == nil {
+			ids[script.Index] = jobID
+		}
+		subs = append(subs, Submission{Script: script.Path, JobID: jobID, Err: err, At: time.Now()})
+	}
+	return subs
+}
+
+// dependencyIDs maps dependency job indexes to the IDs they were submitted as
+func dependencyIDs(after []int, ids map[int]string) ([]string, error) {
+	deps := make([]string, 0, len(after))
+	for _, idx := range after {
+		id, ok := ids[idx]
+		if !ok {
+			return nil, fmt.Errorf("dependency job %d was not submitted", idx+1)
+		}
+		deps = append(deps, id)
+	}
+	return deps, nil
+}
+
+// sbatch submits a script with --parsable and returns the job ID
+func sbatch(script string, deps []string) (string, error) {
+	var args []string
+	if len(deps) > 0 {
+		args = append(args, "--dependency=afterok:"+strings.Join(deps, ":"))
+	}
+	args = append(args, script)
+	return runSbatch(args...)
+}
+
+// runSbatch runs sbatch --parsable with the given arguments and returns the job ID
+func runSbatch(args ...string) (string, error) {
+	var stderr strings.Builder
+	cmd := exec.Command("sbatch", append([]string{"--parsable"}, args...)...)
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%w: %s", err, msg)
+		}
+		return "", err
+	}
+
+	// --parsable prints "jobid" or "jobid;cluster"
+	jobID, _, _ := strings.Cut(strings.TrimSpace(string(out)), ";")
+	if jobID == "" {
+		return "", fmt.Errorf("sbatch returned no job ID")
+	}
+	return jobID, nil
+}
+
+// printSubmissions reports job IDs and failures after submission
+func printSubmissions(subs []Submission) {
+	ok := 0
+	for _, s := range subs {
+		if s.Err != nil {
+			fmt.Fprintf(os.Stderr, "[slurmify] Warning: Could not submit %s: %v\n", s.Script, s.Err)
+			continue
+		}
+		ok++
+		fmt.Printf("[slurmify] Submitted %s as job %s\n", s.Script, s.JobID)
+	}
+	fmt.Printf("[slurmify] Submitted %d/%d job(s)\n", ok, len(subs))
+}
+
+// submitFunc is the shell helper used by the wrapper: it submits one script,
+// appends the job ID to the manifest and optionally sleeps afterwards
+const submitFunc = `submit() {
+  local script=$1 id
+  shift
+  id=$(sbatch --parsable "$@" "$script")
+  id=${id%%;*}
+  printf '%s\t%s\n' "$id" "$script" >> "$manifest"
+  echo "Submitted $script as job $id" >&2
+  if [[ "$throttle" != 0 ]]; then sleep "$throttle"; fi
+  echo "$id"
+}
+`
+
+// writeSubmitWrapper writes a shell script that submits every script in
+// order, records job IDs in the jobs file and passes the IDs of dependencies
+// to --dependency=afterok; a sentinel script, if any, is submitted last
+func writeSubmitWrapper(conf Config, scripts []Script, sentinel string) (string, error) {
+	dir := conf.OutputDir
+	// Log paths in the scripts are relative to where slurmify ran
+	workdir, err := submitWorkdir(conf, `"$(dirname "$0")"`, quoteArg)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	sb.WriteString("#!/bin/bash\n")
+	sb.WriteString("# Submits the batch in order, chaining afterok dependencies\n")
+	sb.WriteString("# Usage: " + submitWrapperName + " [seconds between submissions]\n")
+	sb.WriteString("set -euo pipefail\n")
+	fmt.Fprintf(&sb, "cd %s\n\n", workdir)
+	fmt.Fprintf(&sb, "throttle=${1:-%g}\n", conf.Throttle)
+	fmt.Fprintf(&sb, "manifest=%s\n\n", quoteArg(filepath.Join(dir, jobsFileName)))
+	sb.WriteString(submitFunc + "\n")
+
+	for _, s := range scripts {
+		dep := ""
+		if len(s.After) > 0 {
+			ids := make([]string, len(s.After))
+			for j, idx := range s.After {
+				ids[j] = fmt.Sprintf("${job%d}", idx+1)
+			}
+			dep = " --dependency=afterok:" + strings.Join(ids, ":")
+		}
+		fmt.Fprintf(&sb, "job%d=$(submit %s%s)\n", s.Index+1, quoteArg(s.Path), dep)
+	}
+	if sentinel != "" {
+		ids := make([]string, len(scripts))
+		for i, s := range scripts {
+			ids[i] = fmt.Sprintf("${job%d}", s.Index+1)
+		}
+		fmt.Fprintf(&sb, "sbatch --dependency=afterany:%s %s %s\n", strings.Join(ids, ":"), quoteArg(sentinel), strings.Join(ids, ","))
+	}
+
+	path := filepath.Join(dir, submitWrapperName)
+	if err := writeIfChanged(path, sb.String(), 0755); err != nil {
+		return "", fmt.Errorf("could not write %s: %w", path, err)
+	}
+	return path, nil
+}
+
+// submitWorkdir is the directory slurmify ran in as a shell word, where the
+// generated wrappers submit from. With --deterministic it is given relative to
+// the output directory, which the wrapper names as outdir; quote makes a path a
+// word of the wrapper.
+func submitWorkdir(conf Config, outdir string, quote func(string) string) (string, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	if !conf.Deterministic {
+		return quote(cwd), nil
+	}
+	rel, err := filepath.Rel(absPath(conf.OutputDir), cwd)
+	if err != nil {
+		return "", err
+	}
+	if rel == "." {
+		return outdir, nil
+	}
+	return outdir + "/" + quote(rel), nil
+}
+
+// recordSubmissions appends successful submissions to the jobs file
+func recordSubmissions(dir string, subs []Submission) error {
+	file, err := os.OpenFile(filepath.Join(dir, jobsFileName), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	for _, s := range subs {
+		if s.Err != nil {
+			continue
+		}
+		if _, err := fmt.Fprintf(file, "%s\t%s\n", s.JobID, s.Script); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadJobRecords reads submissions from the state database with --state-db, else the jobs file
+func loadJobRecords(conf Config) ([]JobRecord, error) {
+	if conf.StateDB == "" {
+		return readJobRecords(conf.OutputDir)
+	}
+	db, err := openState(conf.StateDB)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return stateJobRecords(db, conf.OutputDir)
+}
+
+// readJobRecords loads the jobs file, keeping only the latest job per script
+func readJobRecords(dir string) ([]JobRecord, error) {
+	file, err := os.Open(filepath.Join(dir, jobsFileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var records []JobRecord
+	latest := map[string]int{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		jobID, script, ok := strings.Cut(scanner.Text(), "\t")
+		if !ok {
+			continue
+		}
+		rec := JobRecord{JobID: jobID, Script: script}
+		if i, seen := latest[script]; seen {
+			records[i] = rec
+			continue
+		}
+		latest[script] = len(records)
+		records = append(records, rec)
+	}
+	return records, scanner.Err()
+}
+
+// --- STATUS ---
+
+// JobState is the accounting view of a single job
+type JobState struct {
+	State    string
+	Elapsed  string
+	ExitCode string
+}
+
+// runStatus prints the Slurm state of every recorded job
+func runStatus(args []string) error {
+	conf := defaultConfig()
+	fset := newCommandFlags("status", &conf)
+	if err := parseArgs(fset, args, "slurmify status [-O <dir>]"); err != nil {
+		return err
+	}
+
+	records, states, err := recordedStates(conf)
+	if err != nil {
+		return err
+	}
+
+	counts := map[string]int{}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "JOBID\tSTATE\tELAPSED\tEXIT\tSCRIPT")
+	for _, r := range records {
+		st, ok := states[r.JobID]
+		if !ok {
+			st = JobState{State: "UNKNOWN", Elapsed: "-", ExitCode: "-"}
+		}
+		counts[st.State]++
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", r.JobID, st.State, st.Elapsed, st.ExitCode, r.Script)
+	}
+	tw.Flush()
+
+	summary := make([]string, 0, len(counts))
+	for state, n := range counts {
+		summary = append(summary, fmt.Sprintf("%d %s", n, state))
+	}
+	sort.Strings(summary)
+	fmt.Printf("[slurmify] %d job(s): %s\n", len(records), strings.Join(summary, ", "))
+	return nil
+}
+
+// recordedStates loads the recorded jobs of a batch and their sacct states
+func recordedStates(conf Config) ([]JobRecord, map[string]JobState, error) {
+	records, err := loadJobRecords(conf)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not read job records: %w", err)
+	}
+	if len(records) == 0 {
+		return nil, nil, fmt.Errorf("no submitted jobs recorded in %s/", conf.OutputDir)
+	}
+
+	ids := make([]string, len(records))
+	for i, r := range records {
+		ids[i] = r.JobID
+	}
+	states, err := sacctStates(ids)
+	if err != nil {
+		return nil, nil, err
+	}
+	return records, states, nil
+}
+
+// Job states resubmit retries by default
+var retryStates = []string{"FAILED", "TIMEOUT", "OUT_OF_MEMORY", "NODE_FAIL"}
+
+// runResubmit submits again every recorded script whose latest job failed
+func runResubmit(args []string) error {
+	conf := defaultConfig()
+	fset := newCommandFlags("resubmit", &conf)
+	fset.Float64Var(&conf.Throttle, "throttle", conf.Throttle, "Seconds to wait between submissions")
+	wanted := stringList(retryStates)
+	fset.Func("states", "Comma-separated job states to resubmit (default \""+strings.Join(retryStates, ",")+"\")", func(v string) error {
+		wanted = nil
+		for _, st := range strings.Split(v, ",") {
+			wanted = append(wanted, strings.ToUpper(strings.TrimSpace(st)))
+		}
+		return nil
+	})
+	var factor float64
+	fset.Float64Var(&factor, "escalate", 1.5, "Multiply --mem of OUT_OF_MEMORY and --time of TIMEOUT jobs by this factor; 1 keeps them")
+	if err := parseArgs(fset, args, "slurmify resubmit [-O <dir>] [--states FAILED,TIMEOUT,...] [--escalate 1.5]"); err != nil {
+		return err
+	}
+
+	records, states, err := recordedStates(conf)
+	if err != nil {
+		return err
+	}
+
+	var scripts []Script
+	for _, r := range records {
+		st := states[r.JobID]
+		if !slices.Contains(wanted, st.State) {
+			continue
+		}
+		fmt.Printf("[slurmify] Job %s (%s): %s\n", r.JobID, r.Script, st.State)
+		if options, ok := escalatedOptions[st.State]; ok && factor != 1 {
+			option, from, to, err := escalateScript(r.Script, options, factor)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[slurmify] Warning: Could not raise --%s of %s: %v\n", options[0], r.Script, err)
+			} else {
+				fmt.Printf("[slurmify] Raised --%s from %s to %s\n", option, from, to)
+			}
+		}
+		scripts = append(scripts, Script{Path: r.Script, Index: len(scripts)})
+	}
+	if len(scripts) == 0 {
+		fmt.Printf("[slurmify] No jobs to resubmit (states: %s)\n", strings.Join(wanted, ", "))
+		return nil
+	}
+
+	subs := submitScripts(scripts, conf.Throttle)
+	printSubmissions(subs)
+	return recordAll(conf, 0, subs)
+}
+
+// heldJobIDs lists the IDs of submitted jobs whose scripts were generated with --hold
+func heldJobIDs(scripts []Script, subs []Submission) []string {
+	held := map[string]bool{}
+	for _, s := range scripts {
+		held[s.Path] = s.Conf.Hold
+	}
+	var ids []string
+	for _, sub := range subs {
+		if sub.Err == nil && held[sub.Script] {
+			id, _, _ := strings.Cut(sub.JobID, ";")
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// The #SBATCH options resubmit raises for each resource failure; the first one
+// found in the script is raised
+var escalatedOptions = map[string][]string{
+	"OUT_OF_MEMORY": {"mem", "mem-per-cpu"},
+	"TIMEOUT":       {"time"},
+}
+
+// escalateScript scales the value of the first of options set by an #SBATCH line of a script, in place
+func escalateScript(path string, options []string, factor float64) (option, from, to string, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", "", err
+	}
+	quoted := make([]string, len(options))
+	for i, o := range options {
+		quoted[i] = regexp.QuoteMeta(o)
+	}
+	pattern := regexp.MustCompile(`(?m)^#SBATCH --(` + strings.Join(quoted, "|") + `)=(\S+)[ \t]*$`)
+	m := pattern.FindSubmatchIndex(data)
+	if m == nil {
+		return "", "", "", fmt.Errorf("no #SBATCH --%s line", strings.Join(options, " or --"))
+	}
+
+	option = string(data[m[2]:m[3]])
+	from = string(data[m[4]:m[5]])
+	switch option {
+	case "mem", "mem-per-cpu":
+		to, err = scaleMem(from, factor)
+	case "time":
+		to, err = scaleTime(from, factor)
+	}
+	if err != nil {
+		return "", "", "", err
+	}
+
+	out := append(append(slices.Clip(data[:m[4]]), to...), data[m[5]:]...)
+	if err := os.WriteFile(path, out, 0644); err != nil {
+		return "", "", "", err
+	}
+	return option, from, to, nil
+}
+
+// sacctStates queries sacct for the allocation-level state of each job
+func sacctStates(ids []string) (map[string]JobState, error) {
+	var stderr strings.Builder
+	cmd := exec.Command("sacct", "-X", "-n", "-P",
+		"--format=JobID,State,Elapsed,ExitCode", "-j", strings.Join(ids, ","))
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("sacct failed: %w: %s", err, msg)
+		}
+		return nil, fmt.Errorf("sacct failed: %w", err)
+	}
+
+	states := map[string]JobState{}
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Split(strings.TrimSpace(line), "|")
+		if len(fields) < 4 {
+			continue
+		}
+		// States like "CANCELLED by 123" keep only the keyword
+		state, _, _ := strings.Cut(fields[1], " ")
+		states[fields[0]] = JobState{State: state, Elapsed: fields[2], ExitCode: fields[3]}
+	}
+	return states, nil
+}